@@ -4,38 +4,103 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"time"
 
 	"github.com/rossi1/ensync-cli/internal/api"
 	"github.com/rossi1/ensync-cli/internal/domain"
+	"github.com/rossi1/ensync-cli/internal/label"
+	"github.com/rossi1/ensync-cli/internal/metadata"
+	"github.com/rossi1/ensync-cli/internal/policy"
+	"github.com/rossi1/ensync-cli/internal/role"
 	"github.com/spf13/cobra"
 )
 
-func newAccessKeyCmd(client *api.Client) *cobra.Command {
+func newAccessKeyCmd(a *app) *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "access-key",
 		Short: "Manage access keys",
 	}
 
 	cmd.AddCommand(
-		newAccessKeyListCmd(client),
-		newAccessKeyCreateCmd(client),
-		newAccessKeyPermissionsCmd(client),
+		newAccessKeyListCmd(a),
+		newAccessKeyCreateCmd(a),
+		newAccessKeyPermissionsCmd(a),
+		newAccessKeyMetadataCmd(a),
+		newAccessKeyCanCmd(a),
+		newAccessKeyCloneCmd(a),
+		newAccessKeyCompareCmd(a),
 	)
 
 	return cmd
 }
 
-func newAccessKeyListCmd(client *api.Client) *cobra.Command {
+func newAccessKeyListCmd(a *app) *cobra.Command {
 	var pageIndex int
 	var limit int
 	var order string
 	var orderBy string
 	var accessKey string
+	var selector string
+	var expiringWithin string
 
 	cmd := &cobra.Command{
 		Use:   "list",
 		Short: "List access keys",
+		Long: `List access keys together with their labels, owner, description and expiry date.
+
+Filtering with --selector or --expiring-within goes through every page of
+access keys rather than a single one.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
+			sel, err := label.ParseSelector(selector)
+			if err != nil {
+				return err
+			}
+
+			var within time.Duration
+			if expiringWithin != "" {
+				within, err = metadata.ParseDuration(expiringWithin)
+				if err != nil {
+					return err
+				}
+			}
+
+			client, err := a.Client()
+			if err != nil {
+				return err
+			}
+
+			ctx := context.Background()
+			all, err := loadMetadata(ctx, a)
+			if err != nil {
+				return err
+			}
+
+			if len(sel) > 0 || expiringWithin != "" {
+				keys, err := listAllAccessKeys(ctx, client)
+				if err != nil {
+					return err
+				}
+				metadata.Attach(keys, all)
+
+				now := time.Now()
+				filtered := &domain.AccessKeyList{Results: []*domain.AccessKeyPermissions{}}
+				for _, key := range keys {
+					if accessKey != "" && key.Key != accessKey {
+						continue
+					}
+					if !sel.Matches(metadata.Labels(key.Metadata)) {
+						continue
+					}
+					if expiringWithin != "" && !metadata.ExpiresWithin(key.Metadata, now, within) {
+						continue
+					}
+					filtered.Results = append(filtered.Results, key)
+				}
+				filtered.ResultsLength = len(filtered.Results)
+
+				return printOutput(cmd.OutOrStdout(), filtered)
+			}
+
 			params := &api.ListParams{
 				PageIndex: pageIndex,
 				Limit:     limit,
@@ -46,12 +111,13 @@ func newAccessKeyListCmd(client *api.Client) *cobra.Command {
 				},
 			}
 
-			keys, err := client.ListAccessKeys(context.Background(), params)
+			keys, err := client.ListAccessKeys(ctx, params)
 			if err != nil {
 				return fmt.Errorf("failed to list access keys: %w", err)
 			}
+			metadata.Attach(keys.Results, all)
 
-			return printJSON(cmd.OutOrStdout(), keys)
+			return printOutput(cmd.OutOrStdout(), keys)
 		},
 	}
 
@@ -60,17 +126,47 @@ func newAccessKeyListCmd(client *api.Client) *cobra.Command {
 	cmd.Flags().StringVar(&order, "order", "DESC", "Sort order (ASC/DESC)")
 	cmd.Flags().StringVar(&orderBy, "order-by", "createdAt", "Field to order by")
 	cmd.Flags().StringVar(&accessKey, "key", "", "Filter by access key")
+	cmd.Flags().StringVar(&selector, "selector", "", "Filter by labels (e.g. team=payments,env!=prod)")
+	cmd.Flags().StringVar(&expiringWithin, "expiring-within", "", "Only keys expiring within this duration (e.g. 30d, 12h)")
 
 	return cmd
 }
 
-func newAccessKeyCreateCmd(client *api.Client) *cobra.Command {
+// createdAccessKey is printed for every key created, in a shape that secret
+// managers can ingest as is
+type createdAccessKey struct {
+	AccessKey   string              `json:"accessKey"`
+	Permissions *domain.Permissions `json:"permissions"`
+}
+
+func newAccessKeyCreateCmd(a *app) *cobra.Command {
 	var permissionsJSON string
+	var permissionsFile string
+	var roles []string
+	var count int
+	var allowUnknown bool
+	var idempotencyKey string
 
 	cmd := &cobra.Command{
-		Use:   "create",
-		Short: "Create a new access key with permissions",
+		Use:         "create",
+		Short:       "Create a new access key with permissions",
+		Annotations: map[string]string{mutatesAnnotation: "true"},
+		Long: `Create a new access key with permissions.
+
+Permissions are given inline with --permissions, read from a YAML or JSON
+file with --file ("-" reads from stdin), or merged from roles with --role.
+With --count several keys are created with the same permissions and printed
+as a JSON array.
+
+Every create carries an Idempotency-Key header, random unless given with
+--idempotency-key, which is suffixed with -1, -2... when --count is above 1.
+The keys are listed before the first create, and when a create times out
+they are listed again to find the one it may have created before the create
+is sent again with the same key.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
+			if count < 1 {
+				return fmt.Errorf("count must be at least 1")
+			}
 
 			var permissions *domain.Permissions
 			if permissionsJSON != "" {
@@ -79,36 +175,98 @@ func newAccessKeyCreateCmd(client *api.Client) *cobra.Command {
 				}
 			}
 
-			createdKey, err := client.CreateAccessKey(context.Background(), permissions)
+			if permissionsFile != "" {
+				var err error
+				permissions, err = readPermissionsFile(cmd, permissionsFile)
+				if err != nil {
+					return err
+				}
+			}
+
+			if len(roles) > 0 {
+				cfg, err := a.Config()
+				if err != nil {
+					return err
+				}
+				resolved, err := role.Resolve(cfg.Roles, roles)
+				if err != nil {
+					return err
+				}
+				permissions = resolved
+			}
+
+			client, err := a.Client()
 			if err != nil {
-				return fmt.Errorf("failed to create access key: %w", err)
+				return err
 			}
 
-			return printJSON(cmd.OutOrStdout(), createdKey)
+			ctx := context.Background()
+			if err := validatePermissions(ctx, cmd, client, permissions, allowUnknown); err != nil {
+				return err
+			}
+
+			creator := newAccessKeyCreator(client, cmd.ErrOrStderr())
+			created := make([]*createdAccessKey, 0, count)
+			for i := 0; i < count; i++ {
+				keyCtx, err := idempotencyContext(ctx, idempotencyKey, i, count)
+				if err != nil {
+					return err
+				}
+				createdKey, err := creator.create(keyCtx, permissions)
+				if err != nil {
+					// Still print the keys created so far so they are not lost
+					if len(created) > 0 {
+						_ = printOutput(cmd.OutOrStdout(), created)
+					}
+					return fmt.Errorf("failed to create access key %d of %d: %w", i+1, count, err)
+				}
+
+				if len(roles) > 0 {
+					if err := recordRoles(createdKey.AccessKey, roles); err != nil {
+						return err
+					}
+				}
+
+				created = append(created, &createdAccessKey{
+					AccessKey:   createdKey.AccessKey,
+					Permissions: permissions,
+				})
+			}
+
+			if count == 1 {
+				return printOutput(cmd.OutOrStdout(), created[0])
+			}
+			return printOutput(cmd.OutOrStdout(), created)
 		},
 	}
 
 	cmd.Flags().StringVar(&permissionsJSON, "permissions", "", "JSON string representing the permissions")
-	cmd.MarkFlagRequired("name")
+	cmd.Flags().StringVarP(&permissionsFile, "file", "f", "", "YAML or JSON file with the permissions, - for stdin")
+	cmd.Flags().StringArrayVar(&roles, "role", nil, "Role from the config to grant (repeatable)")
+	cmd.Flags().IntVar(&count, "count", 1, "Number of keys to create with the same permissions")
+	cmd.Flags().BoolVar(&allowUnknown, "allow-unknown", false, "Allow permissions for events that do not exist")
+	cmd.Flags().StringVar(&idempotencyKey, "idempotency-key", "", "Idempotency-Key of the request, random by default")
+	cmd.MarkFlagsMutuallyExclusive("permissions", "file", "role")
 
 	return cmd
 }
 
-func newAccessKeyPermissionsCmd(client *api.Client) *cobra.Command {
+func newAccessKeyPermissionsCmd(a *app) *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "permissions",
 		Short: "Manage access key permissions",
 	}
 
 	cmd.AddCommand(
-		newAccessKeyGetPermissionsCmd(client),
-		newAccessKeySetPermissionsCmd(client),
+		newAccessKeyGetPermissionsCmd(a),
+		newAccessKeySetPermissionsCmd(a),
+		newAccessKeyBulkPermissionsCmd(a),
 	)
 
 	return cmd
 }
 
-func newAccessKeyGetPermissionsCmd(client *api.Client) *cobra.Command {
+func newAccessKeyGetPermissionsCmd(a *app) *cobra.Command {
 	var accessKey string
 
 	cmd := &cobra.Command{
@@ -119,12 +277,17 @@ func newAccessKeyGetPermissionsCmd(client *api.Client) *cobra.Command {
 				return fmt.Errorf("access key is required")
 			}
 
+			client, err := a.Client()
+			if err != nil {
+				return err
+			}
+
 			permissions, err := client.GetAccessKeyPermissions(context.Background(), accessKey)
 			if err != nil {
 				return fmt.Errorf("failed to get permissions: %w", err)
 			}
 
-			return printJSON(cmd.OutOrStdout(), permissions)
+			return printOutput(cmd.OutOrStdout(), permissions)
 		},
 	}
 
@@ -134,32 +297,76 @@ func newAccessKeyGetPermissionsCmd(client *api.Client) *cobra.Command {
 	return cmd
 }
 
-func newAccessKeySetPermissionsCmd(client *api.Client) *cobra.Command {
+func newAccessKeySetPermissionsCmd(a *app) *cobra.Command {
 	var accessKey string
 	var permissionsJSON string
+	var roles []string
+	var policyFile string
+	var allowUnknown bool
 
 	cmd := &cobra.Command{
-		Use:   "set",
-		Short: "Set access key permissions",
+		Use:         "set",
+		Short:       "Set access key permissions",
+		Annotations: map[string]string{mutatesAnnotation: "true"},
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if accessKey == "" {
 				return fmt.Errorf("access key is required")
 			}
 
-			if permissionsJSON == "" {
-				return fmt.Errorf("permissions JSON is required")
+			if permissionsJSON == "" && len(roles) == 0 {
+				return fmt.Errorf("permissions JSON or a role is required")
 			}
 
 			var permissions *domain.Permissions
-			if err := json.Unmarshal([]byte(permissionsJSON), &permissions); err != nil {
+			if len(roles) > 0 {
+				cfg, err := a.Config()
+				if err != nil {
+					return err
+				}
+				resolved, err := role.Resolve(cfg.Roles, roles)
+				if err != nil {
+					return err
+				}
+				permissions = resolved
+			} else if err := json.Unmarshal([]byte(permissionsJSON), &permissions); err != nil {
 				return fmt.Errorf("failed to parse permissions JSON: %w", err)
 			}
 
-			err := client.SetAccessKeyPermissions(context.Background(), accessKey, permissions)
+			client, err := a.Client()
 			if err != nil {
+				return err
+			}
+
+			ctx := context.Background()
+			if err := validatePermissions(ctx, cmd, client, permissions, allowUnknown); err != nil {
+				return err
+			}
+
+			if policyFile != "" {
+				p, err := policy.Load(policyFile)
+				if err != nil {
+					return err
+				}
+
+				all, err := loadMetadata(ctx, a)
+				if err != nil {
+					return err
+				}
+
+				subjects := []policy.Subject{{Key: accessKey, Labels: metadata.Labels(all[accessKey]), Permissions: permissions}}
+				if err := enforcePolicy(cmd, p, subjects); err != nil {
+					return err
+				}
+			}
+
+			if err := client.SetAccessKeyPermissions(ctx, accessKey, permissions); err != nil {
 				return fmt.Errorf("failed to set permissions: %w", err)
 			}
 
+			if err := recordRoles(accessKey, roles); err != nil {
+				return err
+			}
+
 			fmt.Fprintln(cmd.OutOrStdout(), "Permissions updated successfully")
 			return nil
 		},
@@ -167,8 +374,11 @@ func newAccessKeySetPermissionsCmd(client *api.Client) *cobra.Command {
 
 	cmd.Flags().StringVar(&accessKey, "key", "", "Access key")
 	cmd.Flags().StringVar(&permissionsJSON, "permissions", "", "JSON string representing permissions")
+	cmd.Flags().StringVar(&policyFile, "policy", "", "Refuse the change if it violates this policy file")
+	cmd.Flags().BoolVar(&allowUnknown, "allow-unknown", false, "Allow permissions for events that do not exist")
+	cmd.Flags().StringArrayVar(&roles, "role", nil, "Role from the config to grant instead of --permissions (repeatable)")
+	cmd.MarkFlagsMutuallyExclusive("permissions", "role")
 	cmd.MarkFlagRequired("key")
-	cmd.MarkFlagRequired("permissions")
 
 	return cmd
 }
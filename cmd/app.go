@@ -0,0 +1,143 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+
+	"github.com/rossi1/ensync-cli/internal/api"
+	"github.com/rossi1/ensync-cli/internal/config"
+	"github.com/rossi1/ensync-cli/internal/platform/ratelimit"
+)
+
+// app gives commands access to the config and the API client. Both are
+// created on first use, after cobra has parsed the flags, so commands that do
+// not talk to the API never need an API key.
+type app struct {
+	root   *cobra.Command
+	cfg    *config.Config
+	client *api.Client
+}
+
+// Config loads the config on first use
+func (a *app) Config() (*config.Config, error) {
+	if a.cfg != nil {
+		return a.cfg, nil
+	}
+
+	cfg, err := config.Load(config.LoadOptions{
+		File:    cfgFile,
+		Profile: profile,
+		Flags:   a.root.PersistentFlags(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config: %w", err)
+	}
+
+	if cfg.PlainTextAPIKey() {
+		fmt.Fprintf(a.root.ErrOrStderr(), "Warning: the API key is stored in plain text in %s, run \"ensync config migrate-secrets\" to encrypt it\n", config.Path(cfgFile))
+	}
+
+	if cfg.Debug && !debug {
+		logger, err := zap.NewDevelopment()
+		if err != nil {
+			return nil, fmt.Errorf("failed to create logger: %w", err)
+		}
+		zap.ReplaceGlobals(logger)
+	}
+	outputFormat = cfg.Output
+
+	a.cfg = cfg
+	return cfg, nil
+}
+
+// passphrase asks for the passphrase of the credentials file
+func (a *app) passphrase(prompt string) ([]byte, error) {
+	// Piped input belongs to the command, such as "create --file -"
+	if !isTerminal(a.root.InOrStdin()) {
+		return nil, fmt.Errorf("the API key is encrypted: set $%s or credentials_key_file", config.PassphraseEnv)
+	}
+	passphrase, err := readSecret(bufio.NewReader(a.root.InOrStdin()), a.root, prompt)
+	return []byte(passphrase), err
+}
+
+// Client creates the API client on first use
+func (a *app) Client() (*api.Client, error) {
+	if a.client != nil {
+		return a.client, nil
+	}
+
+	cfg, err := a.Config()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cfg.ResolveAPIKey(a.passphrase); err != nil {
+		return nil, fmt.Errorf("failed to load API key: %w", err)
+	}
+	if cfg.APIKey == "" {
+		return nil, fmt.Errorf("API key is required: set api_key or credential_helper in %s, or $%s", config.Path(cfgFile), config.APIKeyEnv)
+	}
+
+	opts := []api.ClientOption{
+		api.WithLogger(zap.L()),
+		api.WithTimeout(cfg.Timeout),
+		api.WithMiddleware(api.NewRequestIDMiddleware(), api.NewLoggingMiddleware(zap.L())),
+		api.WithRetryPolicy(api.RetryPolicy{
+			MaxAttempts:        cfg.Retry.MaxAttempts,
+			MinWait:            cfg.Retry.MinWait,
+			MaxWait:            cfg.Retry.MaxWait,
+			MaxRetryAfter:      cfg.Retry.MaxRetryAfter,
+			RetryNonIdempotent: cfg.Retry.NonIdempotent,
+		}),
+		api.WithCircuitBreaker(api.BreakerConfig{
+			Failures: cfg.CircuitBreaker.Failures,
+			Window:   cfg.CircuitBreaker.Window,
+			CoolDown: cfg.CircuitBreaker.CoolDown,
+		}),
+	}
+	if cfg.Credentials != nil {
+		opts = append(opts, api.WithCredentials(cfg.Credentials))
+	}
+
+	rateLimit := cfg.RateLimitConfig()
+	rateLimit.Logger = zap.L()
+	opts = append(opts, api.WithRateLimiter(ratelimit.New(rateLimit), nil))
+
+	transportConfig := api.TransportConfig{
+		ProxyURL:            cfg.ProxyURL,
+		NoProxy:             cfg.NoProxy,
+		MaxIdleConns:        cfg.MaxIdleConns,
+		MaxIdleConnsPerHost: cfg.MaxIdleConnsPerHost,
+		MaxConnsPerHost:     cfg.MaxConnsPerHost,
+		IdleConnTimeout:     cfg.IdleConnTimeout,
+	}
+	if !transportConfig.IsZero() {
+		opts = append(opts, api.WithTransport(transportConfig))
+	}
+
+	tlsConfig := api.TLSConfig{
+		CAFile:             cfg.TLS.CAFile,
+		CertFile:           cfg.TLS.CertFile,
+		KeyFile:            cfg.TLS.KeyFile,
+		ServerName:         cfg.TLS.ServerName,
+		PinnedSPKI:         cfg.TLS.PinnedSPKI,
+		InsecureSkipVerify: cfg.TLS.InsecureSkipVerify,
+	}
+	if !tlsConfig.IsZero() {
+		// Fail here rather than on the first request
+		if _, err := tlsConfig.Build(); err != nil {
+			return nil, fmt.Errorf("invalid TLS configuration: %w", err)
+		}
+		opts = append(opts, api.WithTLS(tlsConfig))
+	}
+	if tlsConfig.InsecureSkipVerify {
+		fmt.Fprintln(a.root.ErrOrStderr(), "WARNING: TLS certificate verification is DISABLED by tls.insecure_skip_verify.")
+		fmt.Fprintln(a.root.ErrOrStderr(), "WARNING: anyone on the network path can read and change the traffic, including the API key.")
+	}
+
+	a.client = api.NewClient(cfg.BaseURL, cfg.APIKey, opts...)
+	return a.client, nil
+}
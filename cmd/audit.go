@@ -0,0 +1,205 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/spf13/cobra"
+
+	"github.com/rossi1/ensync-cli/internal/api"
+	"github.com/rossi1/ensync-cli/internal/domain"
+	"github.com/rossi1/ensync-cli/pkg/permission"
+)
+
+func newAuditCmd(a *app) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "audit",
+		Short: "Audit access keys and events",
+	}
+
+	cmd.AddCommand(
+		newAuditPermissionsCmd(a),
+	)
+
+	return cmd
+}
+
+// danglingPermissions lists the permission entries of a key that reference
+// events which do not exist
+type danglingPermissions struct {
+	Key     string   `json:"key"`
+	Send    []string `json:"send,omitempty"`
+	Receive []string `json:"receive,omitempty"`
+}
+
+type permissionsAuditReport struct {
+	KeysScanned   int                    `json:"keysScanned"`
+	EventsScanned int                    `json:"eventsScanned"`
+	Dangling      []*danglingPermissions `json:"dangling"`
+	UnusedEvents  []string               `json:"unusedEvents"`
+}
+
+func newAuditPermissionsCmd(a *app) *cobra.Command {
+	var fix bool
+	var yes bool
+
+	cmd := &cobra.Command{
+		Use:   "permissions",
+		Short: "Report permissions that reference unknown events and events no key uses",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := a.Config()
+			if err != nil {
+				return err
+			}
+
+			client, err := a.Client()
+			if err != nil {
+				return err
+			}
+
+			ctx := context.Background()
+
+			keys, err := listAllAccessKeys(ctx, client)
+			if err != nil {
+				return err
+			}
+
+			events, err := listAllEvents(ctx, client)
+			if err != nil {
+				return err
+			}
+
+			report := auditPermissions(keys, events)
+			if err := printOutput(cmd.OutOrStdout(), report); err != nil {
+				return err
+			}
+
+			if !fix || len(report.Dangling) == 0 {
+				return nil
+			}
+
+			ok, err := confirmChanges(cmd, cfg, yes, fmt.Sprintf("Prune dangling permissions from %d access key(s)?", len(report.Dangling)))
+			if err != nil {
+				return err
+			}
+			if !ok {
+				fmt.Fprintln(cmd.ErrOrStderr(), "Aborted, no permissions were changed")
+				return nil
+			}
+
+			return pruneDanglingPermissions(ctx, cmd, client, keys, report.Dangling)
+		},
+	}
+
+	cmd.Flags().BoolVar(&fix, "fix", false, "Remove dangling permissions after confirmation")
+	cmd.Flags().BoolVarP(&yes, "yes", "y", false, "Skip the confirmation prompt when fixing")
+
+	return cmd
+}
+
+// auditPermissions compares every send/receive entry against the known event
+// names and collects the events that no key references
+func auditPermissions(keys []*domain.AccessKeyPermissions, events []*domain.Event) *permissionsAuditReport {
+	known := make(map[string]bool, len(events))
+	for _, event := range events {
+		known[event.Name] = false
+	}
+
+	report := &permissionsAuditReport{
+		KeysScanned:   len(keys),
+		EventsScanned: len(events),
+		Dangling:      []*danglingPermissions{},
+		UnusedEvents:  []string{},
+	}
+
+	for _, key := range keys {
+		if key.Permissions == nil {
+			continue
+		}
+
+		dangling := &danglingPermissions{Key: key.Key}
+		for _, entry := range key.Permissions.Send {
+			if !markMatched(known, entry) {
+				dangling.Send = append(dangling.Send, entry)
+			}
+		}
+		for _, entry := range key.Permissions.Receive {
+			if !markMatched(known, entry) {
+				dangling.Receive = append(dangling.Receive, entry)
+			}
+		}
+
+		if len(dangling.Send) > 0 || len(dangling.Receive) > 0 {
+			report.Dangling = append(report.Dangling, dangling)
+		}
+	}
+
+	for name, used := range known {
+		if !used {
+			report.UnusedEvents = append(report.UnusedEvents, name)
+		}
+	}
+	sort.Strings(report.UnusedEvents)
+
+	return report
+}
+
+// markMatched marks every known event the permission entry grants as used and
+// reports whether the entry matched at least one event
+func markMatched(known map[string]bool, entry string) bool {
+	if !permission.IsPattern(entry) {
+		if _, ok := known[entry]; !ok {
+			return false
+		}
+		known[entry] = true
+		return true
+	}
+
+	matched := false
+	for name := range known {
+		if permission.Match(entry, name) {
+			known[name] = true
+			matched = true
+		}
+	}
+	return matched
+}
+
+func pruneDanglingPermissions(ctx context.Context, cmd *cobra.Command, client *api.Client, keys []*domain.AccessKeyPermissions, dangling []*danglingPermissions) error {
+	current := make(map[string]*domain.Permissions, len(keys))
+	for _, key := range keys {
+		current[key.Key] = key.Permissions
+	}
+
+	for _, d := range dangling {
+		permissions := current[d.Key]
+		pruned := &domain.Permissions{
+			Send:    without(permissions.Send, d.Send),
+			Receive: without(permissions.Receive, d.Receive),
+		}
+
+		if err := client.SetAccessKeyPermissions(ctx, d.Key, pruned); err != nil {
+			return fmt.Errorf("failed to prune permissions for %s: %w", d.Key, err)
+		}
+		fmt.Fprintf(cmd.ErrOrStderr(), "Pruned %d dangling permission(s) from %s\n", len(d.Send)+len(d.Receive), d.Key)
+	}
+
+	return nil
+}
+
+// without returns the entries of list that are not in remove, keeping order
+func without(list, remove []string) []string {
+	drop := make(map[string]bool, len(remove))
+	for _, name := range remove {
+		drop[name] = true
+	}
+
+	result := []string{}
+	for _, name := range list {
+		if !drop[name] {
+			result = append(result, name)
+		}
+	}
+	return result
+}
@@ -0,0 +1,337 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/rossi1/ensync-cli/internal/api"
+	"github.com/rossi1/ensync-cli/internal/domain"
+	"github.com/rossi1/ensync-cli/internal/label"
+	"github.com/rossi1/ensync-cli/internal/metadata"
+)
+
+// Status of a change in a bulk report
+const (
+	bulkPending  = "pending"
+	bulkApplied  = "applied"
+	bulkFailed   = "failed"
+	bulkReverted = "reverted"
+	bulkSkipped  = "skipped"
+)
+
+// bulkChange is the before and after permissions of one access key
+type bulkChange struct {
+	Key    string              `json:"key"`
+	Before *domain.Permissions `json:"before"`
+	After  *domain.Permissions `json:"after"`
+	Status string              `json:"status"`
+	Error  string              `json:"error,omitempty"`
+}
+
+// bulkReport records a bulk change so that it can be reviewed and undone
+type bulkReport struct {
+	CreatedAt time.Time     `json:"createdAt"`
+	Undo      bool          `json:"undo,omitempty"`
+	Changes   []*bulkChange `json:"changes"`
+}
+
+func newAccessKeyBulkPermissionsCmd(a *app) *cobra.Command {
+	var matchSend []string
+	var matchReceive []string
+	var selector string
+	var addSend []string
+	var dropSend []string
+	var addReceive []string
+	var dropReceive []string
+	var reportFile string
+	var undoFile string
+	var dryRun bool
+	var yes bool
+	var allowUnknown bool
+	var concurrency int
+
+	cmd := &cobra.Command{
+		Use:   "bulk",
+		Short: "Change the permissions of every access key matching a selector",
+		Long: `Change the permissions of every access key matching a selector.
+
+Keys are selected by their current permissions (--match-send, --match-receive)
+and by their labels (--selector); all given selectors must match. Every
+affected key is previewed before the changes are applied concurrently.
+
+A report with the permissions of every key before and after the change is
+written to --report before anything is applied, and rewritten with the
+outcome of every change afterwards. Pass it to --undo to restore the
+previous permissions of the keys that were not changed since, even when the
+bulk change was interrupted.`,
+		Example: "  ensync access-key permissions bulk --match-receive orders/created --add-receive orders/created.v2",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := a.Config()
+			if err != nil {
+				return err
+			}
+
+			client, err := a.Client()
+			if err != nil {
+				return err
+			}
+
+			ctx := context.Background()
+
+			var report *bulkReport
+			if undoFile != "" {
+				var err error
+				report, err = loadUndoReport(ctx, cmd, client, undoFile, concurrency)
+				if err != nil {
+					return err
+				}
+			} else {
+				if len(matchSend) == 0 && len(matchReceive) == 0 && selector == "" {
+					return fmt.Errorf("at least one of --match-send, --match-receive or --selector is required")
+				}
+
+				sel, err := label.ParseSelector(selector)
+				if err != nil {
+					return err
+				}
+
+				additions := &domain.Permissions{Send: addSend, Receive: addReceive}
+				if err := validatePermissions(ctx, cmd, client, additions, allowUnknown); err != nil {
+					return err
+				}
+
+				keys, err := listAllAccessKeys(ctx, client)
+				if err != nil {
+					return err
+				}
+
+				if selector != "" {
+					all, err := loadMetadata(ctx, a)
+					if err != nil {
+						return err
+					}
+					metadata.Attach(keys, all)
+				}
+
+				report = &bulkReport{CreatedAt: time.Now().UTC(), Changes: []*bulkChange{}}
+				for _, key := range keys {
+					before := key.Permissions
+					if before == nil {
+						before = &domain.Permissions{}
+					}
+
+					if !containsAll(before.Send, matchSend) || !containsAll(before.Receive, matchReceive) {
+						continue
+					}
+					if !sel.Matches(metadata.Labels(key.Metadata)) {
+						continue
+					}
+
+					after := &domain.Permissions{
+						Send:    without(before.Merge(&domain.Permissions{Send: addSend}).Send, dropSend),
+						Receive: without(before.Merge(&domain.Permissions{Receive: addReceive}).Receive, dropReceive),
+					}
+					if equalPermissions(before, after) {
+						continue
+					}
+
+					report.Changes = append(report.Changes, &bulkChange{Key: key.Key, Before: before, After: after, Status: bulkPending})
+				}
+			}
+
+			if err := printOutput(cmd.OutOrStdout(), report.Changes); err != nil {
+				return err
+			}
+
+			pending := 0
+			for _, change := range report.Changes {
+				if change.Status == bulkPending {
+					pending++
+				}
+			}
+			if pending == 0 || dryRun {
+				fmt.Fprintf(cmd.ErrOrStderr(), "%d access key(s) would be changed\n", pending)
+				return nil
+			}
+
+			ok, err := confirmChanges(cmd, cfg, yes, fmt.Sprintf("Change the permissions of %d access key(s)?", pending))
+			if err != nil {
+				return err
+			}
+			if !ok {
+				fmt.Fprintln(cmd.ErrOrStderr(), "Aborted, no permissions were changed")
+				return nil
+			}
+
+			if reportFile == "" {
+				name := "ensync-bulk"
+				if report.Undo {
+					name = "ensync-bulk-undo"
+				}
+				reportFile = fmt.Sprintf("%s-%s.json", name, report.CreatedAt.Format("20060102T150405Z"))
+			}
+
+			// Record every change as pending first, so that an interrupted run
+			// can still be undone
+			if err := writeBulkReport(reportFile, report); err != nil {
+				return err
+			}
+
+			applyBulkChanges(ctx, client, report, concurrency)
+
+			if err := writeBulkReport(reportFile, report); err != nil {
+				return err
+			}
+
+			failed := summarizeBulk(cmd, client, report, reportFile)
+			if failed > 0 {
+				cmd.SilenceUsage = true
+				return fmt.Errorf("%d access key(s) failed to update", failed)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringSliceVar(&matchSend, "match-send", nil, "Select keys that can send all of these events")
+	cmd.Flags().StringSliceVar(&matchReceive, "match-receive", nil, "Select keys that can receive all of these events")
+	cmd.Flags().StringVar(&selector, "selector", "", "Select keys whose labels match (e.g. team=payments)")
+	cmd.Flags().StringSliceVar(&addSend, "add-send", nil, "Events to add to the send permissions")
+	cmd.Flags().StringSliceVar(&dropSend, "drop-send", nil, "Events to remove from the send permissions")
+	cmd.Flags().StringSliceVar(&addReceive, "add-receive", nil, "Events to add to the receive permissions")
+	cmd.Flags().StringSliceVar(&dropReceive, "drop-receive", nil, "Events to remove from the receive permissions")
+	cmd.Flags().StringVar(&reportFile, "report", "", "File to write the undo report to (default ensync-bulk-<time>.json)")
+	cmd.Flags().StringVar(&undoFile, "undo", "", "Restore the permissions recorded in a bulk report")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Only preview the affected keys")
+	cmd.Flags().BoolVarP(&yes, "yes", "y", false, "Skip the confirmation prompt")
+	cmd.Flags().BoolVar(&allowUnknown, "allow-unknown", false, "Allow adding permissions for events that do not exist")
+	cmd.Flags().IntVar(&concurrency, "concurrency", defaultConcurrency, "Number of concurrent permission updates")
+	cmd.MarkFlagsMutuallyExclusive("undo", "match-send")
+	cmd.MarkFlagsMutuallyExclusive("undo", "match-receive")
+	cmd.MarkFlagsMutuallyExclusive("undo", "selector")
+	cmd.MarkFlagsMutuallyExclusive("undo", "add-send")
+	cmd.MarkFlagsMutuallyExclusive("undo", "drop-send")
+	cmd.MarkFlagsMutuallyExclusive("undo", "add-receive")
+	cmd.MarkFlagsMutuallyExclusive("undo", "drop-receive")
+
+	return cmd
+}
+
+// loadUndoReport turns a bulk report into the changes that restore it. Changes
+// still pending when the bulk update was interrupted are restored when they
+// were applied. Keys whose permissions changed since are skipped.
+func loadUndoReport(ctx context.Context, cmd *cobra.Command, client *api.Client, path string, concurrency int) (*bulkReport, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read report: %w", err)
+	}
+
+	var original bulkReport
+	if err := json.Unmarshal(data, &original); err != nil {
+		return nil, fmt.Errorf("failed to parse report: %w", err)
+	}
+
+	var keys []string
+	for _, change := range original.Changes {
+		if change.Status == bulkApplied || change.Status == bulkPending {
+			keys = append(keys, change.Key)
+		}
+	}
+
+	current, err := fetchAccessKeyPermissions(ctx, client, keys, concurrency)
+	if err != nil {
+		return nil, err
+	}
+
+	undo := &bulkReport{CreatedAt: time.Now().UTC(), Undo: true, Changes: []*bulkChange{}}
+	for _, change := range original.Changes {
+		switch change.Status {
+		case bulkApplied:
+		case bulkPending:
+			// The interrupted update never reached this key
+			if equalPermissions(current[change.Key], change.Before) {
+				continue
+			}
+		default:
+			continue
+		}
+
+		revert := &bulkChange{Key: change.Key, Before: change.After, After: change.Before, Status: bulkPending}
+		if !equalPermissions(current[change.Key], change.After) {
+			revert.Status = bulkSkipped
+			revert.Error = "permissions changed since the bulk update"
+			fmt.Fprintf(cmd.ErrOrStderr(), "Skipping %s: %s\n", change.Key, revert.Error)
+		}
+		undo.Changes = append(undo.Changes, revert)
+	}
+
+	return undo, nil
+}
+
+// applyBulkChanges sets the permissions of every pending change concurrently.
+// The client's rate limiter paces the requests.
+func applyBulkChanges(ctx context.Context, client *api.Client, report *bulkReport, concurrency int) {
+	done := bulkApplied
+	if report.Undo {
+		done = bulkReverted
+	}
+
+	forEachConcurrently(ctx, len(report.Changes), concurrency, func(ctx context.Context, i int) error {
+		change := report.Changes[i]
+		if change.Status != bulkPending {
+			return nil
+		}
+
+		if err := client.SetAccessKeyPermissions(ctx, change.Key, change.After); err != nil {
+			change.Status = bulkFailed
+			change.Error = err.Error()
+			return err
+		}
+		change.Status = done
+		return nil
+	})
+}
+
+func writeBulkReport(path string, report *bulkReport) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal report: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	return nil
+}
+
+// summarizeBulk prints the outcome of a bulk change and returns the number of
+// failed keys
+func summarizeBulk(cmd *cobra.Command, client *api.Client, report *bulkReport, reportFile string) int {
+	counts := map[string]int{}
+	for _, change := range report.Changes {
+		counts[change.Status]++
+	}
+
+	fmt.Fprintf(cmd.ErrOrStderr(), "Bulk update finished: %d applied, %d reverted, %d skipped, %d failed\n",
+		counts[bulkApplied], counts[bulkReverted], counts[bulkSkipped], counts[bulkFailed])
+	if breaker := client.CircuitBreaker(); breaker != nil {
+		stats := breaker.Stats()
+		fmt.Fprintf(cmd.ErrOrStderr(), "Circuit breaker %s: opened %d time(s), %d request(s) failed fast\n",
+			stats.State, stats.Opened, stats.Rejected)
+	}
+	fmt.Fprintf(cmd.ErrOrStderr(), "Report written to %s\n", reportFile)
+
+	return counts[bulkFailed]
+}
+
+func containsAll(list, required []string) bool {
+	for _, entry := range required {
+		if !containsString(list, entry) {
+			return false
+		}
+	}
+	return true
+}
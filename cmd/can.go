@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/rossi1/ensync-cli/internal/domain"
+	"github.com/rossi1/ensync-cli/internal/role"
+	"github.com/rossi1/ensync-cli/pkg/permission"
+)
+
+func newAccessKeyCanCmd(a *app) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "can <key> <send|receive> <event>",
+		Short: "Check whether an access key can send or receive an event",
+		Long: `Check whether an access key can send or receive an event, and explain
+which permission entry, prefix pattern or role grants it.
+
+Exits non-zero when the key is not allowed.`,
+		Example: "  ensync access-key can {access-key} send orders/created",
+		Args:    cobra.ExactArgs(3),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := a.Config()
+			if err != nil {
+				return err
+			}
+
+			client, err := a.Client()
+			if err != nil {
+				return err
+			}
+
+			key, event := args[0], qualifyEvent(cfg, args[2])
+
+			direction, err := permission.ParseDirection(args[1])
+			if err != nil {
+				return err
+			}
+
+			resp, err := client.GetAccessKeyPermissions(context.Background(), key)
+			if err != nil {
+				return fmt.Errorf("failed to get permissions: %w", err)
+			}
+
+			// A key the server returns no permissions for has none
+			permissions := &domain.Permissions{}
+			if resp != nil && resp.Permissions != nil {
+				permissions = resp.Permissions
+			}
+
+			var roles map[string]permission.Set
+			assignments, err := role.LoadAssignments(roleAssignmentsPath())
+			if err != nil {
+				return err
+			}
+			for _, name := range assignments[key] {
+				if def, ok := role.Lookup(cfg.Roles, name); ok {
+					if roles == nil {
+						roles = map[string]permission.Set{}
+					}
+					roles[name] = toPermissionSet(def)
+				}
+			}
+
+			evaluator := permission.NewEvaluator(permission.WithRoles(roles))
+			decision := evaluator.Evaluate(toPermissionSet(permissions), direction, event)
+
+			if err := printOutput(cmd.OutOrStdout(), decision); err != nil {
+				return err
+			}
+
+			if !decision.Allowed {
+				cmd.SilenceUsage = true
+				return fmt.Errorf("access key %s cannot %s %s", key, direction, event)
+			}
+			return nil
+		},
+	}
+
+	return cmd
+}
+
+func toPermissionSet(p *domain.Permissions) permission.Set {
+	if p == nil {
+		return permission.Set{}
+	}
+	return permission.Set{Send: p.Send, Receive: p.Receive}
+}
@@ -0,0 +1,177 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/rossi1/ensync-cli/internal/domain"
+)
+
+func newAccessKeyCloneCmd(a *app) *cobra.Command {
+	var from string
+	var addSend []string
+	var dropSend []string
+	var addReceive []string
+	var dropReceive []string
+	var allowUnknown bool
+	var idempotencyKey string
+
+	cmd := &cobra.Command{
+		Use:         "clone",
+		Short:       "Create an access key with the permissions of an existing one",
+		Annotations: map[string]string{mutatesAnnotation: "true"},
+		Long: `Create an access key with the permissions of an existing one.
+
+The create carries an Idempotency-Key header, random unless given with
+--idempotency-key, and is recovered after a timeout the same way as
+"access-key create".`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := a.Client()
+			if err != nil {
+				return err
+			}
+
+			ctx := context.Background()
+
+			source, err := client.GetAccessKeyPermissions(ctx, from)
+			if err != nil {
+				return fmt.Errorf("failed to get permissions: %w", err)
+			}
+
+			current := &domain.Permissions{}
+			if source != nil && source.Permissions != nil {
+				current = source.Permissions
+			}
+
+			permissions := &domain.Permissions{
+				Send:    without(current.Merge(&domain.Permissions{Send: addSend}).Send, dropSend),
+				Receive: without(current.Merge(&domain.Permissions{Receive: addReceive}).Receive, dropReceive),
+			}
+
+			if err := validatePermissions(ctx, cmd, client, permissions, allowUnknown); err != nil {
+				return err
+			}
+
+			ctx, err = idempotencyContext(ctx, idempotencyKey, 0, 1)
+			if err != nil {
+				return err
+			}
+			createdKey, err := newAccessKeyCreator(client, cmd.ErrOrStderr()).create(ctx, permissions)
+			if err != nil {
+				return fmt.Errorf("failed to create access key: %w", err)
+			}
+
+			return printOutput(cmd.OutOrStdout(), &createdAccessKey{
+				AccessKey:   createdKey.AccessKey,
+				Permissions: permissions,
+			})
+		},
+	}
+
+	cmd.Flags().StringVar(&from, "from", "", "Access key to copy permissions from")
+	cmd.Flags().StringSliceVar(&addSend, "add-send", nil, "Events to add to the send permissions")
+	cmd.Flags().StringSliceVar(&dropSend, "drop-send", nil, "Events to remove from the send permissions")
+	cmd.Flags().StringSliceVar(&addReceive, "add-receive", nil, "Events to add to the receive permissions")
+	cmd.Flags().StringSliceVar(&dropReceive, "drop-receive", nil, "Events to remove from the receive permissions")
+	cmd.Flags().BoolVar(&allowUnknown, "allow-unknown", false, "Allow permissions for events that do not exist")
+	cmd.Flags().StringVar(&idempotencyKey, "idempotency-key", "", "Idempotency-Key of the request, random by default")
+	cmd.MarkFlagRequired("from")
+
+	return cmd
+}
+
+// entriesDiff is a set comparison of the entries of two access keys
+type entriesDiff struct {
+	OnlyFirst  []string `json:"onlyFirst"`
+	OnlySecond []string `json:"onlySecond"`
+	Both       []string `json:"both"`
+}
+
+type accessKeyComparison struct {
+	First   string       `json:"first"`
+	Second  string       `json:"second"`
+	Equal   bool         `json:"equal"`
+	Send    *entriesDiff `json:"send"`
+	Receive *entriesDiff `json:"receive"`
+}
+
+func newAccessKeyCompareCmd(a *app) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "compare <key1> <key2>",
+		Short: "Show the difference between the permissions of two access keys",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := a.Client()
+			if err != nil {
+				return err
+			}
+
+			permissions, err := fetchAccessKeyPermissions(context.Background(), client, args, 2)
+			if err != nil {
+				return err
+			}
+
+			first, second := permissions[args[0]], permissions[args[1]]
+			comparison := &accessKeyComparison{
+				First:   args[0],
+				Second:  args[1],
+				Send:    diffEntries(first.Send, second.Send),
+				Receive: diffEntries(first.Receive, second.Receive),
+			}
+			comparison.Equal = len(comparison.Send.OnlyFirst) == 0 && len(comparison.Send.OnlySecond) == 0 &&
+				len(comparison.Receive.OnlyFirst) == 0 && len(comparison.Receive.OnlySecond) == 0
+
+			return printOutput(cmd.OutOrStdout(), comparison)
+		},
+	}
+
+	return cmd
+}
+
+func diffEntries(first, second []string) *entriesDiff {
+	inSecond := make(map[string]bool, len(second))
+	for _, entry := range second {
+		inSecond[entry] = true
+	}
+
+	diff := &entriesDiff{OnlyFirst: []string{}, OnlySecond: []string{}, Both: []string{}}
+	inFirst := make(map[string]bool, len(first))
+	for _, entry := range first {
+		if inFirst[entry] {
+			continue
+		}
+		inFirst[entry] = true
+
+		if inSecond[entry] {
+			diff.Both = append(diff.Both, entry)
+		} else {
+			diff.OnlyFirst = append(diff.OnlyFirst, entry)
+		}
+	}
+	for _, entry := range second {
+		if !inFirst[entry] {
+			diff.OnlySecond = append(diff.OnlySecond, entry)
+			inFirst[entry] = true
+		}
+	}
+
+	return diff
+}
+
+// equalPermissions compares permissions ignoring the order of the entries
+func equalPermissions(a, b *domain.Permissions) bool {
+	if a == nil {
+		a = &domain.Permissions{}
+	}
+	if b == nil {
+		b = &domain.Permissions{}
+	}
+	for _, diff := range []*entriesDiff{diffEntries(a.Send, b.Send), diffEntries(a.Receive, b.Receive)} {
+		if len(diff.OnlyFirst) > 0 || len(diff.OnlySecond) > 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"golang.org/x/sync/errgroup"
+
+	"github.com/rossi1/ensync-cli/internal/api"
+	"github.com/rossi1/ensync-cli/internal/domain"
+)
+
+// defaultConcurrency is the number of in-flight requests used by commands
+// that fan out over many access keys
+const defaultConcurrency = 8
+
+// fetchAccessKeyPermissions gets the permissions of every key concurrently
+// and stops at the first error. The client's rate limiter still applies to
+// each request.
+func fetchAccessKeyPermissions(ctx context.Context, client *api.Client, keys []string, concurrency int) (map[string]*domain.Permissions, error) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(concurrency)
+
+	var mu sync.Mutex
+	results := make(map[string]*domain.Permissions, len(keys))
+
+	for _, key := range keys {
+		// Go blocks while the limit is reached, so no request is scheduled
+		// once one failed
+		if ctx.Err() != nil {
+			break
+		}
+
+		g.Go(func() error {
+			resp, err := client.GetAccessKeyPermissions(ctx, key)
+			if err != nil {
+				return fmt.Errorf("failed to get permissions for %s: %w", key, err)
+			}
+
+			permissions := &domain.Permissions{}
+			if resp != nil && resp.Permissions != nil {
+				permissions = resp.Permissions
+			}
+
+			mu.Lock()
+			results[key] = permissions
+			mu.Unlock()
+			return nil
+		})
+	}
+
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// forEachConcurrently calls fn for every index in [0, n) with at most
+// concurrency calls in flight, and returns the error of each call by index
+func forEachConcurrently(ctx context.Context, n, concurrency int, fn func(ctx context.Context, i int) error) []error {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	errs := make([]error, n)
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		sem <- struct{}{}
+
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			errs[i] = fn(ctx, i)
+		}(i)
+	}
+
+	wg.Wait()
+	return errs
+}
@@ -0,0 +1,431 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/rossi1/ensync-cli/internal/api"
+	"github.com/rossi1/ensync-cli/internal/config"
+)
+
+func newConfigCmd(a *app) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Manage the CLI configuration",
+	}
+
+	cmd.AddCommand(
+		newConfigInitCmd(),
+		newConfigGetCmd(),
+		newConfigSetCmd(),
+		newConfigViewCmd(a),
+		newConfigGetContextsCmd(),
+		newConfigUseContextCmd(),
+		newConfigMigrateSecretsCmd(),
+	)
+
+	return cmd
+}
+
+// warnConfigPermissions prints a warning when the config file is readable by
+// other users
+func warnConfigPermissions(cmd *cobra.Command) {
+	if warning := config.CheckPermissions(cfgFile); warning != "" {
+		fmt.Fprintf(cmd.ErrOrStderr(), "Warning: %s\n", warning)
+	}
+}
+
+func newConfigInitCmd() *cobra.Command {
+	var keyFile string
+	var plainText bool
+
+	cmd := &cobra.Command{
+		Use:   "init",
+		Short: "Interactively create or update the config file",
+		Long: `Interactively create or update the config file.
+
+Asks for the base URL and API key and tests that the EnSync API can be reached
+with them. The base URL is written to the config file and the API key to the
+encrypted credentials file, under a new passphrase or --key-file as with
+"ensync login". With --plain-text the API key is written to the config file
+instead. With --profile the settings are written to that profile.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			warnConfigPermissions(cmd)
+
+			in := bufio.NewReader(cmd.InOrStdin())
+			out := cmd.ErrOrStderr()
+
+			currentURL, _, err := config.GetValue(cfgFile, config.ProfileKey(profile, "base_url"))
+			if err != nil {
+				return err
+			}
+			defaultURL := config.DefaultBaseURL
+			if s, ok := currentURL.(string); ok && s != "" {
+				defaultURL = s
+			}
+
+			baseURL, err := ask(in, out, "Base URL", defaultURL)
+			if err != nil {
+				return err
+			}
+
+			apiKey, err := readSecret(in, cmd, "API key: ")
+			if err != nil {
+				return err
+			}
+			if apiKey = strings.TrimSpace(apiKey); apiKey == "" {
+				return fmt.Errorf("API key is required")
+			}
+
+			fmt.Fprintf(out, "Testing connection to %s... ", baseURL)
+			if err := testConnection(baseURL, apiKey); err != nil {
+				fmt.Fprintf(out, "failed: %v\n", err)
+
+				answer, err := ask(in, out, "Save the config anyway? [y/N]", "")
+				if err != nil {
+					return err
+				}
+				if answer = strings.ToLower(answer); answer != "y" && answer != "yes" {
+					cmd.SilenceUsage = true
+					return fmt.Errorf("config not saved")
+				}
+			} else {
+				fmt.Fprintln(out, "ok")
+			}
+
+			values := map[string]interface{}{
+				config.ProfileKey(profile, "base_url"): baseURL,
+			}
+			if plainText {
+				values[config.ProfileKey(profile, "api_key")] = apiKey
+				if err := config.SetValues(cfgFile, values); err != nil {
+					return err
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "Config written to %s\n", config.Path(cfgFile))
+				return nil
+			}
+
+			secret, err := config.Secret(keyFile, func(string) ([]byte, error) {
+				return readNewPassphrase(in, cmd)
+			}, "")
+			if err != nil {
+				return err
+			}
+
+			store := config.CredentialStore()
+			if err := store.Save(config.StoreProfile(profile), apiKey, secret); err != nil {
+				return err
+			}
+
+			if keyFile != "" {
+				path, err := filepath.Abs(keyFile)
+				if err != nil {
+					return fmt.Errorf("failed to resolve key file: %w", err)
+				}
+				values[config.ProfileKey(profile, "credentials_key_file")] = path
+			}
+			if err := config.SetValues(cfgFile, values); err != nil {
+				return err
+			}
+			// A plain-text key left from before would take precedence
+			if err := config.UnsetValues(cfgFile, []string{config.ProfileKey(profile, "api_key")}); err != nil {
+				return err
+			}
+
+			fmt.Fprintf(cmd.OutOrStdout(), "Config written to %s\n", config.Path(cfgFile))
+			fmt.Fprintf(cmd.OutOrStdout(), "API key of profile %q stored encrypted in %s\n", config.StoreProfile(profile), store.Path())
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&keyFile, "key-file", "", "Encrypt the API key with the contents of this file instead of a passphrase")
+	cmd.Flags().BoolVar(&plainText, "plain-text", false, "Write the API key to the config file unencrypted")
+	cmd.MarkFlagsMutuallyExclusive("key-file", "plain-text")
+
+	return cmd
+}
+
+// ask prints a question and reads one line of answer, returning def when the
+// answer is empty
+func ask(in *bufio.Reader, out io.Writer, question, def string) (string, error) {
+	if def != "" {
+		fmt.Fprintf(out, "%s [%s]: ", question, def)
+	} else {
+		fmt.Fprintf(out, "%s: ", question)
+	}
+
+	answer, err := in.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", fmt.Errorf("failed to read answer: %w", err)
+	}
+
+	answer = strings.TrimSpace(answer)
+	if answer == "" {
+		return def, nil
+	}
+	return answer, nil
+}
+
+// testConnection makes a cheap authenticated request to the API
+func testConnection(baseURL, apiKey string) error {
+	client := api.NewClient(baseURL, apiKey, api.WithTimeout(10*time.Second))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	_, err := client.ListEvents(ctx, &api.ListParams{
+		PageIndex: 0,
+		Limit:     1,
+		Order:     "DESC",
+		OrderBy:   "createdAt",
+	})
+	return err
+}
+
+func newConfigGetCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "get <key>",
+		Short: "Print a setting from the config file",
+		Long: `Print a setting from the config file. With --profile the setting is read
+from that profile. Nested settings are addressed with dots, e.g.
+profiles.prod.base_url.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			warnConfigPermissions(cmd)
+
+			value, ok, err := config.GetValue(cfgFile, config.ProfileKey(profile, args[0]))
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return fmt.Errorf("%s is not set", args[0])
+			}
+
+			if s, ok := value.(string); ok {
+				fmt.Fprintln(cmd.OutOrStdout(), s)
+				return nil
+			}
+			return printOutput(cmd.OutOrStdout(), value)
+		},
+	}
+
+	return cmd
+}
+
+func newConfigSetCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "set <key> <value>",
+		Short: "Store a setting in the config file",
+		Long: `Store a setting in the config file. With --profile the setting is stored
+in that profile. Values are stored as given, except for settings that are
+booleans, numbers, durations or lists, which must parse as such.`,
+		Example: "  ensync config set base_url https://ensync.example.com/api/v1/ensync\n" +
+			"  ensync --profile prod config set confirm_mutations true",
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			warnConfigPermissions(cmd)
+
+			if err := config.SetValue(cfgFile, config.ProfileKey(profile, args[0]), args[1]); err != nil {
+				return err
+			}
+
+			fmt.Fprintf(cmd.OutOrStdout(), "Set %s in %s\n", args[0], config.Path(cfgFile))
+			return nil
+		},
+	}
+
+	return cmd
+}
+
+func newConfigViewCmd(a *app) *cobra.Command {
+	var showOrigin bool
+
+	cmd := &cobra.Command{
+		Use:   "view",
+		Short: "Print the config file with secrets redacted",
+		Long: `Print the config file with secrets redacted.
+
+With --show-origin the effective settings are printed instead, each with the
+place its value came from: a flag, an environment variable, the active
+profile, the config file or the built-in default, in that order of
+precedence.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			warnConfigPermissions(cmd)
+
+			if showOrigin {
+				cfg, err := a.Config()
+				if err != nil {
+					return err
+				}
+				return printSettings(cmd.OutOrStdout(), cfg.Settings())
+			}
+
+			settings, err := config.View(cfgFile)
+			if err != nil {
+				return err
+			}
+
+			return printYAML(cmd.OutOrStdout(), settings)
+		},
+	}
+
+	cmd.Flags().BoolVar(&showOrigin, "show-origin", false, "Print the effective settings and where each one came from")
+
+	return cmd
+}
+
+// printSettings prints one setting per line, prefixed with its origin
+func printSettings(w io.Writer, settings []config.Setting) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, setting := range settings {
+		value := setting.Value
+		switch value.(type) {
+		case map[string]interface{}, []interface{}:
+			data, err := json.Marshal(value)
+			if err != nil {
+				return fmt.Errorf("failed to encode %s: %w", setting.Key, err)
+			}
+			value = string(data)
+		case nil:
+			value = ""
+		}
+		fmt.Fprintf(tw, "%s\t%s=%v\n", setting.Origin, setting.Key, value)
+	}
+	return tw.Flush()
+}
+
+// contextInfo describes a profile without its credentials
+type contextInfo struct {
+	Name    string `json:"name"`
+	Current bool   `json:"current"`
+	*config.Profile
+	Timeout string `json:"timeout,omitempty"`
+}
+
+func newConfigGetContextsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "get-contexts",
+		Short: "List the profiles defined in the config file",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			profiles, current, err := config.Contexts(cfgFile)
+			if err != nil {
+				return err
+			}
+
+			contexts := []*contextInfo{}
+			for _, name := range config.ProfileNames(profiles) {
+				contexts = append(contexts, &contextInfo{
+					Name:    name,
+					Current: name == current,
+					Profile: profiles[name],
+					Timeout: formatDuration(profiles[name].Timeout),
+				})
+			}
+
+			return printOutput(cmd.OutOrStdout(), contexts)
+		},
+	}
+
+	return cmd
+}
+
+func newConfigUseContextCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "use-context <profile>",
+		Short: "Set the profile used when neither --profile nor $" + config.ProfileEnv + " is given",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := config.UseContext(cfgFile, args[0]); err != nil {
+				return err
+			}
+
+			fmt.Fprintf(cmd.OutOrStdout(), "Switched to profile %q\n", args[0])
+			return nil
+		},
+	}
+
+	return cmd
+}
+
+func formatDuration(d time.Duration) string {
+	if d == 0 {
+		return ""
+	}
+	return d.String()
+}
+
+func newConfigMigrateSecretsCmd() *cobra.Command {
+	var keyFile string
+
+	cmd := &cobra.Command{
+		Use:   "migrate-secrets",
+		Short: "Move plain-text API keys from the config file to the encrypted credentials file",
+		Long: `Move plain-text API keys from the config file to the encrypted credentials file.
+
+Every api_key in the config file, at the top level and in profiles, is
+encrypted with one passphrase (or --key-file) and removed from the config
+file. See "ensync login" for how the keys are decrypted.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+
+			keys, err := config.PlainTextAPIKeys(cfgFile)
+			if err != nil {
+				return err
+			}
+			if len(keys) == 0 {
+				fmt.Fprintf(cmd.OutOrStdout(), "No plain-text API keys in %s\n", config.Path(cfgFile))
+				return nil
+			}
+
+			in := bufio.NewReader(cmd.InOrStdin())
+			secret, err := config.Secret(keyFile, func(string) ([]byte, error) {
+				return readNewPassphrase(in, cmd)
+			}, "")
+			if err != nil {
+				return err
+			}
+
+			store := config.CredentialStore()
+			settings := make([]string, 0, len(keys))
+			for _, key := range keys {
+				if err := store.Save(key.Profile, key.APIKey, secret); err != nil {
+					return err
+				}
+				settings = append(settings, key.Key)
+			}
+
+			// Only remove the keys once all of them are safely stored
+			if err := config.UnsetValues(cfgFile, settings); err != nil {
+				return err
+			}
+
+			if keyFile != "" {
+				path, err := filepath.Abs(keyFile)
+				if err != nil {
+					return fmt.Errorf("failed to resolve key file: %w", err)
+				}
+				if err := config.SetValue(cfgFile, "credentials_key_file", path); err != nil {
+					return err
+				}
+			}
+
+			for _, key := range keys {
+				fmt.Fprintf(cmd.OutOrStdout(), "Moved %s to %s as profile %q\n", key.Key, store.Path(), key.Profile)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&keyFile, "key-file", "", "Encrypt with the contents of this file instead of a passphrase")
+
+	return cmd
+}
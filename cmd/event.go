@@ -4,30 +4,34 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"strings"
 
 	"github.com/spf13/cobra"
 
 	"github.com/rossi1/ensync-cli/internal/api"
+	"github.com/rossi1/ensync-cli/internal/config"
 	"github.com/rossi1/ensync-cli/internal/domain"
+	"github.com/rossi1/ensync-cli/pkg/permission"
 )
 
-func newEventCmd(client *api.Client) *cobra.Command {
+func newEventCmd(a *app) *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "event",
 		Short: "Manage events",
 	}
 
 	cmd.AddCommand(
-		newEventListCmd(client),
-		newEventCreateCmd(client),
-		newEventUpdateCmd(client),
-		newEventGetByNameCmd(client),
+		newEventListCmd(a),
+		newEventCreateCmd(a),
+		newEventUpdateCmd(a),
+		newEventGetByNameCmd(a),
+		newEventWhoCmd(a),
 	)
 
 	return cmd
 }
 
-func newEventListCmd(client *api.Client) *cobra.Command {
+func newEventListCmd(a *app) *cobra.Command {
 	var pageIndex int
 	var limit int
 	var order string
@@ -37,6 +41,11 @@ func newEventListCmd(client *api.Client) *cobra.Command {
 		Use:   "list",
 		Short: "List events",
 		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := a.Client()
+			if err != nil {
+				return err
+			}
+
 			params := &api.ListParams{
 				PageIndex: pageIndex,
 				Limit:     limit,
@@ -49,7 +58,7 @@ func newEventListCmd(client *api.Client) *cobra.Command {
 				return fmt.Errorf("failed to list events: %w", err)
 			}
 
-			return printJSON(cmd.OutOrStdout(), events)
+			return printOutput(cmd.OutOrStdout(), events)
 		},
 	}
 
@@ -61,13 +70,20 @@ func newEventListCmd(client *api.Client) *cobra.Command {
 	return cmd
 }
 
-func newEventCreateCmd(client *api.Client) *cobra.Command {
+func newEventCreateCmd(a *app) *cobra.Command {
 	var name string
 	var payload string
+	var idempotencyKey string
 
 	cmd := &cobra.Command{
-		Use:   "create",
-		Short: "Create a new event definition",
+		Use:         "create",
+		Short:       "Create a new event definition",
+		Annotations: map[string]string{mutatesAnnotation: "true"},
+		Long: `Create a new event definition.
+
+The request carries an Idempotency-Key header, random unless given with
+--idempotency-key. When it times out, the event is looked up before the
+create is sent again with the same key.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if name == "" {
 				return fmt.Errorf("name is required")
@@ -78,13 +94,27 @@ func newEventCreateCmd(client *api.Client) *cobra.Command {
 				return fmt.Errorf("invalid payload JSON: %w", err)
 			}
 
+			cfg, err := a.Config()
+			if err != nil {
+				return err
+			}
+			name = qualifyEvent(cfg, name)
+
 			event := &domain.Event{
 				Name:    name,
 				Payload: payloadMap,
 			}
 
-			ctx := context.Background()
-			err := client.CreateEvent(ctx, event)
+			client, err := a.Client()
+			if err != nil {
+				return err
+			}
+
+			ctx, err := idempotencyContext(context.Background(), idempotencyKey, 0, 1)
+			if err != nil {
+				return err
+			}
+			err = createEvent(ctx, cmd.ErrOrStderr(), client, event)
 			if err != nil {
 				return fmt.Errorf("failed to create event: %w", err)
 			}
@@ -96,19 +126,21 @@ func newEventCreateCmd(client *api.Client) *cobra.Command {
 
 	cmd.Flags().StringVar(&name, "name", "", "Event name")
 	cmd.Flags().StringVar(&payload, "payload", "{}", "Event payload in JSON format")
+	cmd.Flags().StringVar(&idempotencyKey, "idempotency-key", "", "Idempotency-Key of the request, random by default")
 	cmd.MarkFlagRequired("name")
 
 	return cmd
 }
 
-func newEventUpdateCmd(client *api.Client) *cobra.Command {
+func newEventUpdateCmd(a *app) *cobra.Command {
 	var id int64
 	var name string
 	var payload string
 
 	cmd := &cobra.Command{
-		Use:   "update",
-		Short: "Update an existing event definition",
+		Use:         "update",
+		Short:       "Update an existing event definition",
+		Annotations: map[string]string{mutatesAnnotation: "true"},
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if id == 0 {
 				return fmt.Errorf("id is required")
@@ -119,14 +151,27 @@ func newEventUpdateCmd(client *api.Client) *cobra.Command {
 				return fmt.Errorf("invalid payload JSON: %w", err)
 			}
 
+			cfg, err := a.Config()
+			if err != nil {
+				return err
+			}
+			if name != "" {
+				name = qualifyEvent(cfg, name)
+			}
+
 			event := &domain.Event{
 				ID:      id,
 				Name:    name,
 				Payload: payloadMap,
 			}
 
+			client, err := a.Client()
+			if err != nil {
+				return err
+			}
+
 			ctx := context.Background()
-			err := client.UpdateEvent(ctx, event)
+			err = client.UpdateEvent(ctx, event)
 			if err != nil {
 				return fmt.Errorf("failed to update event: %w", err)
 			}
@@ -144,7 +189,7 @@ func newEventUpdateCmd(client *api.Client) *cobra.Command {
 	return cmd
 }
 
-func newEventGetByNameCmd(client *api.Client) *cobra.Command {
+func newEventGetByNameCmd(a *app) *cobra.Command {
 	var name string
 
 	cmd := &cobra.Command{
@@ -155,13 +200,24 @@ func newEventGetByNameCmd(client *api.Client) *cobra.Command {
 				return fmt.Errorf("name is required")
 			}
 
+			cfg, err := a.Config()
+			if err != nil {
+				return err
+			}
+			name = qualifyEvent(cfg, name)
+
+			client, err := a.Client()
+			if err != nil {
+				return err
+			}
+
 			ctx := context.Background()
 			event, err := client.GetEventByName(ctx, name)
 			if err != nil {
 				return fmt.Errorf("failed to get event: %w", err)
 			}
 
-			return printJSON(cmd.OutOrStdout(), event)
+			return printOutput(cmd.OutOrStdout(), event)
 		},
 	}
 
@@ -170,3 +226,95 @@ func newEventGetByNameCmd(client *api.Client) *cobra.Command {
 
 	return cmd
 }
+
+// eventGrant is an access key that can use an event, and the permission
+// entry that grants it
+type eventGrant struct {
+	Key     string `json:"key"`
+	Entry   string `json:"entry"`
+	Pattern bool   `json:"pattern"`
+}
+
+type eventWhoResult struct {
+	Event   string        `json:"event"`
+	Send    []*eventGrant `json:"send"`
+	Receive []*eventGrant `json:"receive"`
+}
+
+func newEventWhoCmd(a *app) *cobra.Command {
+	var name string
+	var concurrency int
+
+	cmd := &cobra.Command{
+		Use:   "who",
+		Short: "List the access keys that can send or receive an event",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if name == "" {
+				return fmt.Errorf("name is required")
+			}
+
+			cfg, err := a.Config()
+			if err != nil {
+				return err
+			}
+			name = qualifyEvent(cfg, name)
+
+			client, err := a.Client()
+			if err != nil {
+				return err
+			}
+
+			ctx := context.Background()
+			keys, err := listAllAccessKeys(ctx, client)
+			if err != nil {
+				return err
+			}
+
+			names := make([]string, 0, len(keys))
+			for _, key := range keys {
+				names = append(names, key.Key)
+			}
+
+			permissions, err := fetchAccessKeyPermissions(ctx, client, names, concurrency)
+			if err != nil {
+				return err
+			}
+
+			result := &eventWhoResult{
+				Event:   name,
+				Send:    []*eventGrant{},
+				Receive: []*eventGrant{},
+			}
+			for _, key := range names {
+				if entry, ok := permission.MatchAny(permissions[key].Send, name); ok {
+					result.Send = append(result.Send, &eventGrant{Key: key, Entry: entry, Pattern: permission.IsPattern(entry)})
+				}
+				if entry, ok := permission.MatchAny(permissions[key].Receive, name); ok {
+					result.Receive = append(result.Receive, &eventGrant{Key: key, Entry: entry, Pattern: permission.IsPattern(entry)})
+				}
+			}
+
+			return printOutput(cmd.OutOrStdout(), result)
+		},
+	}
+
+	cmd.Flags().StringVar(&name, "name", "", "Event name")
+	cmd.Flags().IntVar(&concurrency, "concurrency", defaultConcurrency, "Number of concurrent permission requests")
+	cmd.MarkFlagRequired("name")
+
+	return cmd
+}
+
+// qualifyEvent prefixes an event name with the namespace from the config,
+// unless the name already starts with it
+func qualifyEvent(cfg *config.Config, name string) string {
+	if cfg.Namespace == "" {
+		return name
+	}
+
+	namespace := strings.TrimSuffix(cfg.Namespace, "/") + "/"
+	if strings.HasPrefix(name, namespace) {
+		return name
+	}
+	return namespace + name
+}
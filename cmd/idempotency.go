@@ -0,0 +1,128 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/rossi1/ensync-cli/internal/api"
+	"github.com/rossi1/ensync-cli/internal/domain"
+)
+
+// idempotencyContext returns ctx carrying the Idempotency-Key of operation
+// index out of count. A pinned key is used as is for a single operation and
+// suffixed with the operation number otherwise.
+func idempotencyContext(ctx context.Context, pinned string, index, count int) (context.Context, error) {
+	key := pinned
+	switch {
+	case key != "" && count > 1:
+		key = fmt.Sprintf("%s-%d", pinned, index+1)
+	case key == "":
+		var err error
+		key, err = api.NewIdempotencyKey()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return api.UseIdempotencyKey(ctx, key), nil
+}
+
+// timedOut reports whether err leaves the outcome of a create unknown. A
+// cancelled or expired ctx is not retried.
+func timedOut(ctx context.Context, err error) bool {
+	return err != nil && ctx.Err() == nil && api.IsTimeout(err)
+}
+
+// createEvent creates an event, and after a timeout looks it up before
+// sending the create again, since the server may not deduplicate requests
+// by their Idempotency-Key
+func createEvent(ctx context.Context, stderr io.Writer, client *api.Client, event *domain.Event) error {
+	err := client.CreateEvent(ctx, event)
+	if !timedOut(ctx, err) {
+		return err
+	}
+
+	fmt.Fprintf(stderr, "Creating event '%s' timed out, checking whether it was created\n", event.Name)
+	if _, getErr := client.GetEventByName(ctx, event.Name); getErr == nil {
+		return nil
+	} else if !api.IsNotFound(getErr) {
+		return err
+	}
+
+	fmt.Fprintf(stderr, "Event '%s' was not created, retrying with Idempotency-Key %s\n", event.Name, api.IdempotencyKey(ctx))
+	return client.CreateEvent(ctx, event)
+}
+
+// accessKeyCreator creates access keys, recovering from timeouts. Access keys
+// have no name to look up and the server does not report the Idempotency-Key
+// a key was created with, so the keys are listed before the first create, and
+// after a timeout a single new key with the same permissions is taken as the
+// one the request created. With several such keys it refuses to guess, since
+// another client may have created them in the meantime.
+type accessKeyCreator struct {
+	client *api.Client
+	stderr io.Writer
+	// known holds the keys listed before the first create and the keys created
+	// since, which are never candidates
+	known map[string]bool
+}
+
+func newAccessKeyCreator(client *api.Client, stderr io.Writer) *accessKeyCreator {
+	return &accessKeyCreator{client: client, stderr: stderr}
+}
+
+func (c *accessKeyCreator) create(ctx context.Context, permissions *domain.Permissions) (*domain.AccessKey, error) {
+	if c.known == nil {
+		keys, err := listAllAccessKeys(ctx, c.client)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list access keys: %w", err)
+		}
+		c.known = make(map[string]bool, len(keys))
+		for _, key := range keys {
+			c.known[key.Key] = true
+		}
+	}
+
+	created, err := c.client.CreateAccessKey(ctx, permissions)
+	if timedOut(ctx, err) {
+		created, err = c.recover(ctx, permissions, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c.known[created.AccessKey] = true
+	return created, nil
+}
+
+// recover looks for the key of a create that timed out among the keys that
+// were not known before, and creates it again when none has the same
+// permissions
+func (c *accessKeyCreator) recover(ctx context.Context, permissions *domain.Permissions, createErr error) (*domain.AccessKey, error) {
+	fmt.Fprintln(c.stderr, "Creating access key timed out, checking whether it was created")
+
+	keys, err := listAllAccessKeys(ctx, c.client)
+	if err != nil {
+		return nil, createErr
+	}
+
+	var candidates []string
+	for _, key := range keys {
+		if !c.known[key.Key] && equalPermissions(key.Permissions, permissions) {
+			candidates = append(candidates, key.Key)
+		}
+	}
+
+	switch len(candidates) {
+	case 0:
+		fmt.Fprintf(c.stderr, "Access key was not created, retrying with Idempotency-Key %s\n", api.IdempotencyKey(ctx))
+		return c.client.CreateAccessKey(ctx, permissions)
+	case 1:
+		fmt.Fprintf(c.stderr, "Access key %s is new and has the requested permissions, assuming it was created by the request that timed out\n", candidates[0])
+		return &domain.AccessKey{AccessKey: candidates[0]}, nil
+	default:
+		return nil, fmt.Errorf("%w: the access key may have been created, but %d new keys have the requested permissions (%s), "+
+			"check them before creating another one", createErr, len(candidates), strings.Join(candidates, ", "))
+	}
+}
@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+
+	"github.com/rossi1/ensync-cli/internal/api"
+	"github.com/rossi1/ensync-cli/internal/catalog"
+	"github.com/rossi1/ensync-cli/internal/domain"
+)
+
+// readInput reads a whole file, or the command's input stream when path is "-"
+func readInput(cmd *cobra.Command, path string) ([]byte, error) {
+	if path == "-" {
+		data, err := io.ReadAll(cmd.InOrStdin())
+		if err != nil {
+			return nil, fmt.Errorf("failed to read stdin: %w", err)
+		}
+		return data, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return data, nil
+}
+
+// readPermissionsFile reads permissions from a YAML or JSON file
+func readPermissionsFile(cmd *cobra.Command, path string) (*domain.Permissions, error) {
+	data, err := readInput(cmd, path)
+	if err != nil {
+		return nil, err
+	}
+
+	// YAML is a superset of JSON, so this handles both formats
+	var permissions domain.Permissions
+	if err := yaml.Unmarshal(data, &permissions); err != nil {
+		return nil, fmt.Errorf("failed to parse permissions file: %w", err)
+	}
+
+	if permissions.Send == nil {
+		permissions.Send = []string{}
+	}
+	if permissions.Receive == nil {
+		permissions.Receive = []string{}
+	}
+	return &permissions, nil
+}
+
+// validatePermissions checks every event named in the permissions against
+// the catalog. Unknown names are refused unless allowUnknown is set, in which
+// case they are only reported.
+func validatePermissions(ctx context.Context, cmd *cobra.Command, client *api.Client, permissions *domain.Permissions, allowUnknown bool) error {
+	validator := catalog.NewValidator(client, func(ctx context.Context) ([]*domain.Event, error) {
+		return listAllEvents(ctx, client)
+	})
+
+	unknown, err := validator.CheckPermissions(ctx, permissions)
+	if err != nil {
+		return fmt.Errorf("failed to validate permissions: %w", err)
+	}
+	if len(unknown) == 0 {
+		return nil
+	}
+
+	for _, u := range unknown {
+		fmt.Fprintf(cmd.ErrOrStderr(), "Warning: %s\n", u)
+	}
+
+	if allowUnknown {
+		return nil
+	}
+
+	cmd.SilenceUsage = true
+	return fmt.Errorf("%d permission(s) reference unknown events, pass --allow-unknown to use them anyway", len(unknown))
+}
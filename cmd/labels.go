@@ -0,0 +1,39 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/rossi1/ensync-cli/internal/label"
+)
+
+// loadKeyLabels reads a YAML or JSON file mapping access keys to labels
+// written as "team=payments,external". The labels of a key add to and
+// override those of its metadata. An empty path returns no labels.
+func loadKeyLabels(path string) (map[string]map[string]string, error) {
+	labels := map[string]map[string]string{}
+	if path == "" {
+		return labels, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read labels file: %w", err)
+	}
+
+	var raw map[string]string
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse labels file: %w", err)
+	}
+
+	for key, value := range raw {
+		parsed, err := label.Parse(value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse labels file: access key %s: %w", key, err)
+		}
+		labels[key] = parsed
+	}
+	return labels, nil
+}
@@ -0,0 +1,120 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/rossi1/ensync-cli/internal/config"
+)
+
+func newLoginCmd() *cobra.Command {
+	var keyFile string
+	var apiKeyStdin bool
+
+	cmd := &cobra.Command{
+		Use:   "login",
+		Short: "Store the API key of a profile in the encrypted credentials file",
+		Long: `Store the API key of a profile in the encrypted credentials file.
+
+The key is encrypted with AES-256-GCM under a key derived from a passphrase,
+or from the contents of --key-file. Commands decrypt it in memory, reading the
+passphrase from $ENSYNC_PASSPHRASE or asking for it. With --key-file the file
+is also recorded as credentials_key_file for the profile.`,
+		Example: `  ensync --profile prod login
+  pass show ensync/prod | ensync --profile prod login --api-key-stdin --key-file ~/.ensync/key`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+
+			name, err := config.ActiveProfile(config.LoadOptions{File: cfgFile, Profile: profile})
+			if err != nil {
+				return err
+			}
+
+			in := bufio.NewReader(cmd.InOrStdin())
+
+			var apiKey string
+			if apiKeyStdin {
+				data, err := io.ReadAll(in)
+				if err != nil {
+					return fmt.Errorf("failed to read API key: %w", err)
+				}
+				apiKey = strings.TrimSpace(string(data))
+			} else {
+				apiKey, err = readSecret(in, cmd, "API key: ")
+				if err != nil {
+					return err
+				}
+				apiKey = strings.TrimSpace(apiKey)
+			}
+			if apiKey == "" {
+				return fmt.Errorf("API key is required")
+			}
+
+			secret, err := config.Secret(keyFile, func(string) ([]byte, error) {
+				return readNewPassphrase(in, cmd)
+			}, "")
+			if err != nil {
+				return err
+			}
+
+			store := config.CredentialStore()
+			if err := store.Save(config.StoreProfile(name), apiKey, secret); err != nil {
+				return err
+			}
+
+			if keyFile != "" {
+				path, err := filepath.Abs(keyFile)
+				if err != nil {
+					return fmt.Errorf("failed to resolve key file: %w", err)
+				}
+				if err := config.SetValue(cfgFile, config.ProfileKey(name, "credentials_key_file"), path); err != nil {
+					return err
+				}
+			}
+
+			fmt.Fprintf(cmd.OutOrStdout(), "API key of profile %q stored encrypted in %s\n", config.StoreProfile(name), store.Path())
+
+			if plain, _, _ := config.GetValue(cfgFile, config.ProfileKey(name, "api_key")); plain != nil && plain != "" {
+				fmt.Fprintf(cmd.ErrOrStderr(), "Warning: the plain-text api_key in %s still takes precedence, run \"ensync config migrate-secrets\" to remove it\n", config.Path(cfgFile))
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&keyFile, "key-file", "", "Encrypt with the contents of this file instead of a passphrase")
+	cmd.Flags().BoolVar(&apiKeyStdin, "api-key-stdin", false, "Read the API key from stdin instead of prompting")
+
+	return cmd
+}
+
+func newLogoutCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "logout",
+		Short: "Remove the API key of a profile from the encrypted credentials file",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name, err := config.ActiveProfile(config.LoadOptions{File: cfgFile, Profile: profile})
+			if err != nil {
+				return err
+			}
+
+			removed, err := config.CredentialStore().Delete(config.StoreProfile(name))
+			if err != nil {
+				return err
+			}
+
+			if !removed {
+				fmt.Fprintf(cmd.OutOrStdout(), "No API key stored for profile %q\n", config.StoreProfile(name))
+				return nil
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "API key of profile %q removed\n", config.StoreProfile(name))
+			return nil
+		},
+	}
+
+	return cmd
+}
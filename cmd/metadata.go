@@ -0,0 +1,235 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/rossi1/ensync-cli/internal/config"
+	"github.com/rossi1/ensync-cli/internal/domain"
+	"github.com/rossi1/ensync-cli/internal/metadata"
+)
+
+// newMetadataStore returns the access key metadata store selected in the config
+func newMetadataStore(a *app) (metadata.Store, error) {
+	cfg, err := a.Config()
+	if err != nil {
+		return nil, err
+	}
+
+	switch cfg.MetadataStore {
+	case metadata.BackendServer:
+		client, err := a.Client()
+		if err != nil {
+			return nil, err
+		}
+		return metadata.NewServerStore(client), nil
+	case metadata.BackendFile, "":
+		path := cfg.MetadataFile
+		if path == "" {
+			path = filepath.Join(config.Dir(), metadata.DefaultFile)
+		}
+		return metadata.NewFileStore(path), nil
+	default:
+		return nil, fmt.Errorf("unknown metadata_store %q (expected %s or %s)", cfg.MetadataStore, metadata.BackendFile, metadata.BackendServer)
+	}
+}
+
+// loadMetadata returns the metadata of every access key from the configured store
+func loadMetadata(ctx context.Context, a *app) (map[string]*domain.AccessKeyMetadata, error) {
+	store, err := newMetadataStore(a)
+	if err != nil {
+		return nil, err
+	}
+	return store.Load(ctx)
+}
+
+func newAccessKeyMetadataCmd(a *app) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "metadata",
+		Short: "Manage access key labels, owners, descriptions and expiry dates",
+	}
+
+	cmd.AddCommand(
+		newAccessKeyMetadataGetCmd(a),
+		newAccessKeyMetadataSetCmd(a),
+		newAccessKeyMetadataDeleteCmd(a),
+	)
+
+	return cmd
+}
+
+func newAccessKeyMetadataGetCmd(a *app) *cobra.Command {
+	var accessKey string
+
+	cmd := &cobra.Command{
+		Use:   "get",
+		Short: "Get the metadata of an access key",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			all, err := loadMetadata(context.Background(), a)
+			if err != nil {
+				return err
+			}
+
+			md, ok := all[accessKey]
+			if !ok {
+				return fmt.Errorf("no metadata for access key %s", accessKey)
+			}
+
+			return printOutput(cmd.OutOrStdout(), md)
+		},
+	}
+
+	cmd.Flags().StringVar(&accessKey, "key", "", "Access key")
+	cmd.MarkFlagRequired("key")
+
+	return cmd
+}
+
+func newAccessKeyMetadataSetCmd(a *app) *cobra.Command {
+	var accessKey string
+	var labels []string
+	var owner string
+	var description string
+	var expires string
+
+	cmd := &cobra.Command{
+		Use:   "set",
+		Short: "Set labels, owner, description or expiry date of an access key",
+		Long: `Set labels, owner, description or expiry date of an access key.
+
+Only the given fields are changed. Labels are given as key=value, and a
+label is removed with key- (e.g. --label team-).`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := context.Background()
+
+			cfg, err := a.Config()
+			if err != nil {
+				return err
+			}
+
+			store, err := newMetadataStore(a)
+			if err != nil {
+				return err
+			}
+
+			if cfg.MetadataStore == metadata.BackendServer {
+				if err := confirmMutation(cmd, cfg); err != nil {
+					return err
+				}
+			}
+
+			all, err := store.Load(ctx)
+			if err != nil {
+				return err
+			}
+
+			md := all[accessKey]
+			if md == nil {
+				md = &domain.AccessKeyMetadata{}
+			}
+
+			for _, label := range labels {
+				if name, ok := strings.CutSuffix(label, "-"); ok && !strings.Contains(label, "=") {
+					delete(md.Labels, name)
+					continue
+				}
+
+				name, value, _ := strings.Cut(label, "=")
+				if name == "" {
+					return fmt.Errorf("invalid label %q: expected key=value", label)
+				}
+				if md.Labels == nil {
+					md.Labels = map[string]string{}
+				}
+				md.Labels[name] = value
+			}
+
+			if cmd.Flags().Changed("owner") {
+				md.Owner = owner
+			}
+			if cmd.Flags().Changed("description") {
+				md.Description = description
+			}
+			if cmd.Flags().Changed("expires") {
+				expiresAt, err := parseExpiry(expires)
+				if err != nil {
+					return err
+				}
+				md.ExpiresAt = expiresAt
+			}
+
+			if err := store.Save(ctx, accessKey, md); err != nil {
+				return err
+			}
+
+			return printOutput(cmd.OutOrStdout(), md)
+		},
+	}
+
+	cmd.Flags().StringVar(&accessKey, "key", "", "Access key")
+	cmd.Flags().StringArrayVar(&labels, "label", nil, "Label as key=value, or key- to remove it (repeatable)")
+	cmd.Flags().StringVar(&owner, "owner", "", "Team or person owning the key")
+	cmd.Flags().StringVar(&description, "description", "", "What the key is used for")
+	cmd.Flags().StringVar(&expires, "expires", "", "Expiry date (YYYY-MM-DD or RFC 3339), empty to clear")
+	cmd.MarkFlagRequired("key")
+
+	return cmd
+}
+
+func newAccessKeyMetadataDeleteCmd(a *app) *cobra.Command {
+	var accessKey string
+
+	cmd := &cobra.Command{
+		Use:   "delete",
+		Short: "Delete all metadata of an access key",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := a.Config()
+			if err != nil {
+				return err
+			}
+
+			store, err := newMetadataStore(a)
+			if err != nil {
+				return err
+			}
+
+			if cfg.MetadataStore == metadata.BackendServer {
+				if err := confirmMutation(cmd, cfg); err != nil {
+					return err
+				}
+			}
+
+			if err := store.Delete(context.Background(), accessKey); err != nil {
+				return err
+			}
+
+			fmt.Fprintln(cmd.OutOrStdout(), "Metadata deleted successfully")
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&accessKey, "key", "", "Access key")
+	cmd.MarkFlagRequired("key")
+
+	return cmd
+}
+
+// parseExpiry parses a date or timestamp. An empty string clears the expiry.
+func parseExpiry(s string) (*time.Time, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	for _, layout := range []string{time.RFC3339, time.DateOnly} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return &t, nil
+		}
+	}
+
+	return nil, fmt.Errorf("invalid expiry date %q: expected YYYY-MM-DD or RFC 3339", s)
+}
@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Output formats selectable with the output setting of a profile
+const (
+	outputJSON = "json"
+	outputYAML = "yaml"
+)
+
+// outputFormat is the format printOutput uses, set from the loaded config
+var outputFormat = outputJSON
+
+// printOutput prints the given data in the configured output format
+func printOutput(w io.Writer, v interface{}) error {
+	switch outputFormat {
+	case outputJSON, "":
+		return printJSON(w, v)
+	case outputYAML:
+		return printYAML(w, v)
+	default:
+		return fmt.Errorf("unsupported output format %q (expected %s or %s)", outputFormat, outputJSON, outputYAML)
+	}
+}
+
+// printYAML prints the given data as YAML, using the same field names as
+// its JSON encoding
+func printYAML(w io.Writer, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to marshal output: %w", err)
+	}
+
+	var generic interface{}
+	if err := json.Unmarshal(data, &generic); err != nil {
+		return fmt.Errorf("failed to marshal output: %w", err)
+	}
+
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(generic); err != nil {
+		return fmt.Errorf("failed to marshal output: %w", err)
+	}
+	return encoder.Close()
+}
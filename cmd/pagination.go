@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/rossi1/ensync-cli/internal/api"
+	"github.com/rossi1/ensync-cli/internal/domain"
+)
+
+// pageSize is the largest page the API accepts for list endpoints
+const pageSize = 100
+
+// listAllAccessKeys walks every page of ListAccessKeys and returns all keys
+func listAllAccessKeys(ctx context.Context, client *api.Client) ([]*domain.AccessKeyPermissions, error) {
+	var keys []*domain.AccessKeyPermissions
+
+	for pageIndex := 0; ; pageIndex++ {
+		page, err := client.ListAccessKeys(ctx, &api.ListParams{
+			PageIndex: pageIndex,
+			Limit:     pageSize,
+			Order:     "ASC",
+			OrderBy:   "createdAt",
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, page.Results...)
+		if len(page.Results) < pageSize || len(keys) >= page.ResultsLength {
+			return keys, nil
+		}
+	}
+}
+
+// listAllEvents walks every page of ListEvents and returns all events
+func listAllEvents(ctx context.Context, client *api.Client) ([]*domain.Event, error) {
+	var events []*domain.Event
+
+	for pageIndex := 0; ; pageIndex++ {
+		page, err := client.ListEvents(ctx, &api.ListParams{
+			PageIndex: pageIndex,
+			Limit:     pageSize,
+			Order:     "ASC",
+			OrderBy:   "name",
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list events: %w", err)
+		}
+
+		events = append(events, page.Results...)
+		if len(page.Results) < pageSize || len(events) >= page.ResultsLength {
+			return events, nil
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/rossi1/ensync-cli/internal/domain"
+	"github.com/rossi1/ensync-cli/internal/label"
+	"github.com/rossi1/ensync-cli/internal/metadata"
+	"github.com/rossi1/ensync-cli/internal/policy"
+)
+
+func newPolicyCmd(a *app) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "policy",
+		Short: "Check access key permissions against a policy",
+	}
+
+	cmd.AddCommand(
+		newPolicyCheckCmd(a),
+	)
+
+	return cmd
+}
+
+type policyCheckReport struct {
+	KeysChecked int                `json:"keysChecked"`
+	Rules       int                `json:"rules"`
+	Violations  []policy.Violation `json:"violations"`
+}
+
+func newPolicyCheckCmd(a *app) *cobra.Command {
+	var policyFile string
+	var labelsFile string
+	var accessKey string
+	var permissionsJSON string
+
+	cmd := &cobra.Command{
+		Use:   "check",
+		Short: "Evaluate policy rules against live or proposed permissions",
+		Long: `Evaluate policy rules against the permissions of every access key.
+
+With --permissions the rules are evaluated against the proposed permissions
+of a single key instead, so a change can be checked before running
+"access-key permissions set". The command exits non-zero when any rule is
+violated.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			p, err := policy.Load(policyFile)
+			if err != nil {
+				return err
+			}
+
+			fileLabels, err := loadKeyLabels(labelsFile)
+			if err != nil {
+				return err
+			}
+
+			ctx := context.Background()
+			all, err := loadMetadata(ctx, a)
+			if err != nil {
+				return err
+			}
+
+			var subjects []policy.Subject
+			if permissionsJSON != "" {
+				if accessKey == "" {
+					accessKey = "proposed"
+				}
+
+				var permissions *domain.Permissions
+				if err := json.Unmarshal([]byte(permissionsJSON), &permissions); err != nil {
+					return fmt.Errorf("failed to parse permissions JSON: %w", err)
+				}
+
+				subjects = append(subjects, policy.Subject{
+					Key:         accessKey,
+					Labels:      label.Merge(metadata.Labels(all[accessKey]), fileLabels[accessKey]),
+					Permissions: permissions,
+				})
+			} else {
+				client, err := a.Client()
+				if err != nil {
+					return err
+				}
+
+				keys, err := listAllAccessKeys(ctx, client)
+				if err != nil {
+					return err
+				}
+
+				metadata.Attach(keys, all)
+				for _, key := range keys {
+					if accessKey != "" && key.Key != accessKey {
+						continue
+					}
+					subjects = append(subjects, policy.Subject{
+						Key:         key.Key,
+						Labels:      label.Merge(metadata.Labels(key.Metadata), fileLabels[key.Key]),
+						Permissions: key.Permissions,
+					})
+				}
+			}
+
+			return enforcePolicy(cmd, p, subjects)
+		},
+	}
+
+	cmd.Flags().StringVarP(&policyFile, "file", "f", "", "Policy file (YAML or JSON)")
+	cmd.Flags().StringVar(&labelsFile, "labels", "", "YAML or JSON file mapping access keys to labels, added to their metadata labels")
+	cmd.Flags().StringVar(&accessKey, "key", "", "Only check this access key")
+	cmd.Flags().StringVar(&permissionsJSON, "permissions", "", "Proposed permissions JSON to check instead of the live state")
+	cmd.MarkFlagRequired("file")
+
+	return cmd
+}
+
+// enforcePolicy prints the result of checking subjects against the policy
+// and returns an error when any rule is violated
+func enforcePolicy(cmd *cobra.Command, p *policy.Policy, subjects []policy.Subject) error {
+	report := &policyCheckReport{
+		KeysChecked: len(subjects),
+		Rules:       len(p.Rules),
+		Violations:  p.Check(subjects),
+	}
+
+	if err := printOutput(cmd.OutOrStdout(), report); err != nil {
+		return err
+	}
+
+	if len(report.Violations) > 0 {
+		cmd.SilenceUsage = true
+		return fmt.Errorf("%d policy violation(s) found", len(report.Violations))
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// confirm asks the user a yes/no question on the command's input stream.
+// Anything other than "y" or "yes" is treated as a refusal. Commands reading
+// their data from stdin cannot be asked, the answer would be taken from the
+// data.
+func confirm(cmd *cobra.Command, prompt string) (bool, error) {
+	if readsStdin(cmd) {
+		cmd.SilenceUsage = true
+		return false, fmt.Errorf("cannot ask for confirmation while reading --file from stdin, pass a file path instead")
+	}
+
+	fmt.Fprintf(cmd.ErrOrStderr(), "%s [y/N]: ", prompt)
+
+	answer, err := readLine(cmd.InOrStdin())
+	if err != nil {
+		return false, fmt.Errorf("failed to read confirmation: %w", err)
+	}
+
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true, nil
+	default:
+		return false, nil
+	}
+}
+
+// readsStdin reports whether the command reads its data from stdin, as
+// "access-key create --file -" does
+func readsStdin(cmd *cobra.Command) bool {
+	flag := cmd.Flags().Lookup("file")
+	return flag != nil && flag.Value.String() == "-"
+}
+
+// readLine reads one line a byte at a time, so that nothing after the line
+// is consumed and later reads of the same input see the rest of it
+func readLine(r io.Reader) (string, error) {
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		n, err := r.Read(b)
+		if n > 0 {
+			if b[0] == '\n' {
+				return string(line), nil
+			}
+			line = append(line, b[0])
+		}
+		if err == io.EOF {
+			return string(line), nil
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+}
+
+// readSecret prints a prompt and reads one line without echoing it when the
+// input is a terminal
+func readSecret(in *bufio.Reader, cmd *cobra.Command, prompt string) (string, error) {
+	fmt.Fprint(cmd.ErrOrStderr(), prompt)
+
+	if isTerminal(cmd.InOrStdin()) {
+		if err := setEcho(false); err == nil {
+			defer func() {
+				_ = setEcho(true)
+				fmt.Fprintln(cmd.ErrOrStderr())
+			}()
+		}
+	}
+
+	answer, err := in.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", fmt.Errorf("failed to read input: %w", err)
+	}
+	return strings.TrimRight(answer, "\r\n"), nil
+}
+
+// readNewPassphrase asks for a passphrase twice and checks both match
+func readNewPassphrase(in *bufio.Reader, cmd *cobra.Command) ([]byte, error) {
+	passphrase, err := readSecret(in, cmd, "New passphrase: ")
+	if err != nil {
+		return nil, err
+	}
+	if passphrase == "" {
+		return nil, fmt.Errorf("passphrase must not be empty")
+	}
+
+	again, err := readSecret(in, cmd, "Repeat passphrase: ")
+	if err != nil {
+		return nil, err
+	}
+	if again != passphrase {
+		return nil, fmt.Errorf("passphrases do not match")
+	}
+	return []byte(passphrase), nil
+}
+
+// isTerminal reports whether r is the process's stdin attached to a terminal
+func isTerminal(r io.Reader) bool {
+	f, ok := r.(*os.File)
+	if !ok || f != os.Stdin {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// setEcho turns terminal echo on or off with stty, which avoids a dependency
+// on a terminal library for this one use
+func setEcho(on bool) error {
+	arg := "-echo"
+	if on {
+		arg = "echo"
+	}
+	stty := exec.Command("stty", arg)
+	stty.Stdin = os.Stdin
+	return stty.Run()
+}
@@ -0,0 +1,127 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/rossi1/ensync-cli/internal/label"
+	"github.com/rossi1/ensync-cli/internal/metadata"
+	"github.com/rossi1/ensync-cli/internal/report"
+)
+
+func newReportCmd(a *app) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "report",
+		Short: "Generate permission reports",
+	}
+
+	cmd.AddCommand(
+		newReportMatrixCmd(a),
+	)
+
+	return cmd
+}
+
+func newReportMatrixCmd(a *app) *cobra.Command {
+	var format string
+	var prefix string
+	var selector string
+	var labelsFile string
+	var output string
+
+	cmd := &cobra.Command{
+		Use:   "matrix",
+		Short: "Render a grid of access keys against events with send/receive marks",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := report.CheckFormat(format); err != nil {
+				return err
+			}
+
+			sel, err := label.ParseSelector(selector)
+			if err != nil {
+				return err
+			}
+
+			fileLabels, err := loadKeyLabels(labelsFile)
+			if err != nil {
+				return err
+			}
+
+			client, err := a.Client()
+			if err != nil {
+				return err
+			}
+
+			ctx := context.Background()
+			keys, err := listAllAccessKeys(ctx, client)
+			if err != nil {
+				return err
+			}
+
+			all, err := loadMetadata(ctx, a)
+			if err != nil {
+				return err
+			}
+			metadata.Attach(keys, all)
+
+			labels := map[string]string{}
+			selected := keys[:0]
+			for _, key := range keys {
+				keyLabels := label.Merge(metadata.Labels(key.Metadata), fileLabels[key.Key])
+				if !sel.Matches(keyLabels) {
+					continue
+				}
+				selected = append(selected, key)
+				labels[key.Key] = label.Format(keyLabels)
+			}
+			keys = selected
+
+			events, err := listAllEvents(ctx, client)
+			if err != nil {
+				return err
+			}
+
+			matrix := report.NewMatrix(keys, events, report.MatrixOptions{
+				Prefix: prefix,
+				Labels: labels,
+			})
+
+			if output == "" {
+				if err := matrix.Write(cmd.OutOrStdout(), format); err != nil {
+					return fmt.Errorf("failed to write report: %w", err)
+				}
+				return nil
+			}
+			return writeMatrixFile(matrix, format, output)
+		},
+	}
+
+	cmd.Flags().StringVar(&format, "format", report.FormatCSV, "Output format (csv/md/html)")
+	cmd.Flags().StringVar(&prefix, "prefix", "", "Only include events whose name starts with this prefix")
+	cmd.Flags().StringVar(&selector, "selector", "", "Only include keys whose labels match this selector (e.g. team=payments)")
+	cmd.Flags().StringVar(&labelsFile, "labels", "", "YAML or JSON file mapping access keys to labels, added to their metadata labels")
+	cmd.Flags().StringVarP(&output, "output", "o", "", "Write the report to a file instead of stdout")
+
+	return cmd
+}
+
+// writeMatrixFile renders the matrix into a file, reporting errors of the
+// final flush when the file is closed
+func writeMatrixFile(matrix *report.Matrix, format, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %w", err)
+	}
+
+	if err := matrix.Write(f, format); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"sort"
+
+	"github.com/spf13/cobra"
+
+	"github.com/rossi1/ensync-cli/internal/config"
+	"github.com/rossi1/ensync-cli/internal/domain"
+	"github.com/rossi1/ensync-cli/internal/role"
+)
+
+func newRoleCmd(a *app) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "role",
+		Short: "Manage roles, the reusable permission bundles defined in the config",
+	}
+
+	cmd.AddCommand(
+		newRoleListCmd(a),
+		newRoleDiffCmd(a),
+	)
+
+	return cmd
+}
+
+func newRoleListCmd(a *app) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List the roles defined in the config",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := a.Config()
+			if err != nil {
+				return err
+			}
+
+			roles := cfg.Roles
+			if roles == nil {
+				roles = map[string]*domain.Permissions{}
+			}
+			return printOutput(cmd.OutOrStdout(), roles)
+		},
+	}
+
+	return cmd
+}
+
+// roleDrift is an access key whose permissions no longer match its roles
+type roleDrift struct {
+	Key   string   `json:"key"`
+	Roles []string `json:"roles"`
+	*role.Drift
+	want *domain.Permissions
+}
+
+func newRoleDiffCmd(a *app) *cobra.Command {
+	var only string
+	var apply bool
+	var yes bool
+	var concurrency int
+
+	cmd := &cobra.Command{
+		Use:   "diff",
+		Short: "Show access keys whose permissions are out of date with their roles",
+		Long: `Compare every access key that was granted roles through "access-key create --role"
+or "access-key permissions set --role" with the current role definitions.
+
+With --apply the out of date keys are updated to match their roles.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			assignments, err := role.LoadAssignments(roleAssignmentsPath())
+			if err != nil {
+				return err
+			}
+
+			var keys []string
+			for _, key := range assignments.Keys() {
+				if only == "" || slices.ContainsFunc(assignments[key], func(name string) bool { return role.SameName(name, only) }) {
+					keys = append(keys, key)
+				}
+			}
+
+			cfg, err := a.Config()
+			if err != nil {
+				return err
+			}
+
+			client, err := a.Client()
+			if err != nil {
+				return err
+			}
+
+			ctx := context.Background()
+			current, err := fetchAccessKeyPermissions(ctx, client, keys, concurrency)
+			if err != nil {
+				return err
+			}
+
+			drifted := []*roleDrift{}
+			for _, key := range keys {
+				want, err := role.Resolve(cfg.Roles, assignments[key])
+				if err != nil {
+					return fmt.Errorf("access key %s: %w", key, err)
+				}
+
+				drift := role.Diff(want, current[key])
+				if !drift.InSync() {
+					drifted = append(drifted, &roleDrift{Key: key, Roles: assignments[key], Drift: drift, want: want})
+				}
+			}
+
+			if err := printOutput(cmd.OutOrStdout(), drifted); err != nil {
+				return err
+			}
+
+			if !apply || len(drifted) == 0 {
+				return nil
+			}
+
+			ok, err := confirmChanges(cmd, cfg, yes, fmt.Sprintf("Update %d access key(s) to match their roles?", len(drifted)))
+			if err != nil {
+				return err
+			}
+			if !ok {
+				fmt.Fprintln(cmd.ErrOrStderr(), "Aborted, no permissions were changed")
+				return nil
+			}
+
+			for _, d := range drifted {
+				if err := client.SetAccessKeyPermissions(ctx, d.Key, d.want); err != nil {
+					return fmt.Errorf("failed to update %s: %w", d.Key, err)
+				}
+				fmt.Fprintf(cmd.ErrOrStderr(), "Updated %s\n", d.Key)
+			}
+
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&only, "role", "", "Only check keys granted this role")
+	cmd.Flags().BoolVar(&apply, "apply", false, "Update out of date keys after confirmation")
+	cmd.Flags().BoolVarP(&yes, "yes", "y", false, "Skip the confirmation prompt when applying")
+	cmd.Flags().IntVar(&concurrency, "concurrency", defaultConcurrency, "Number of concurrent permission requests")
+
+	return cmd
+}
+
+func roleAssignmentsPath() string {
+	return filepath.Join(config.Dir(), role.AssignmentsFile)
+}
+
+// recordRoles remembers the roles granted to an access key so that role diff
+// can find it later. Granting no roles forgets the key.
+func recordRoles(key string, roles []string) error {
+	path := roleAssignmentsPath()
+
+	assignments, err := role.LoadAssignments(path)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := assignments[key]; !ok && len(roles) == 0 {
+		return nil
+	}
+
+	if len(roles) == 0 {
+		delete(assignments, key)
+	} else {
+		sorted := append([]string(nil), roles...)
+		sort.Strings(sorted)
+		assignments[key] = sorted
+	}
+
+	return assignments.Save(path)
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
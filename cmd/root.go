@@ -1,58 +1,116 @@
 package cmd
 
 import (
+	"fmt"
+
 	"github.com/spf13/cobra"
 	"go.uber.org/zap"
 
-	"github.com/rossi1/ensync-cli/internal/api"
 	"github.com/rossi1/ensync-cli/internal/config"
 )
 
 var (
 	cfgFile string
+	profile string
 	debug   bool
 )
 
+// Command annotations read by the root command before running a command
+const (
+	// mutatesAnnotation marks commands that always change server state
+	mutatesAnnotation = "ensync/mutates"
+)
+
 func Execute() error {
 	rootCmd := &cobra.Command{
 		Use:   "ensync",
 		Short: "EnSync CLI tool",
-		PersistentPreRun: func(cmd *cobra.Command, args []string) {
-			// Initialize logger based on debug flag
-			var logger *zap.Logger
-			var err error
-			if debug {
-				logger, err = zap.NewDevelopment()
-			} else {
-				logger, err = zap.NewProduction()
-			}
-			if err != nil {
-				panic(err)
-			}
-			zap.ReplaceGlobals(logger)
-		},
 	}
 
-	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.ensync/config.yaml)")
-	rootCmd.PersistentFlags().BoolVar(&debug, "debug", false, "enable debug mode")
+	// The config and client are created by the commands that need them, once
+	// flags are parsed, so that --config, --profile and --debug apply
+	a := &app{root: rootCmd}
 
-	cfg, err := config.Load()
-	if err != nil {
-		zap.L().Fatal("Failed to load config", zap.Error(err))
+	rootCmd.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
+		// Initialize logger based on debug flag
+		var logger *zap.Logger
+		var err error
+		if debug {
+			logger, err = zap.NewDevelopment()
+		} else {
+			logger, err = zap.NewProduction()
+		}
+		if err != nil {
+			panic(err)
+		}
+		zap.ReplaceGlobals(logger)
+
+		if !hasAnnotation(cmd, mutatesAnnotation) {
+			return nil
+		}
+
+		cfg, err := a.Config()
+		if err != nil {
+			return err
+		}
+		return confirmMutation(cmd, cfg)
 	}
 
-	client := api.NewClient(
-		cfg.BaseURL,
-		cfg.APIKey,
-		api.WithLogger(zap.L()),
-		api.WithRateLimit(10, 20),
-	)
+	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.ensync/config.yaml)")
+	rootCmd.PersistentFlags().StringVar(&profile, "profile", "", "config profile to use (default is $"+config.ProfileEnv+" or the current context)")
+	rootCmd.PersistentFlags().BoolVar(&debug, "debug", false, "enable debug mode")
+	rootCmd.PersistentFlags().String("namespace", "", "prefix for event names given on the command line (default is the namespace setting)")
 
 	rootCmd.AddCommand(
-		newEventCmd(client),
-		newAccessKeyCmd(client),
+		newEventCmd(a),
+		newAccessKeyCmd(a),
+		newRoleCmd(a),
+		newAuditCmd(a),
+		newReportCmd(a),
+		newPolicyCmd(a),
+		newConfigCmd(a),
+		newLoginCmd(),
+		newLogoutCmd(),
 		newVersionCmd(),
 	)
 
 	return rootCmd.Execute()
 }
+
+// hasAnnotation reports whether the command or any of its parents carries
+// the annotation
+func hasAnnotation(cmd *cobra.Command, key string) bool {
+	for c := cmd; c != nil; c = c.Parent() {
+		if c.Annotations[key] == "true" {
+			return true
+		}
+	}
+	return false
+}
+
+// confirmMutation asks for confirmation before changing server state when the
+// active profile requires it
+func confirmMutation(cmd *cobra.Command, cfg *config.Config) error {
+	if !cfg.ConfirmMutations {
+		return nil
+	}
+
+	ok, err := confirm(cmd, fmt.Sprintf("Profile %q requires confirmation for changes. Run %q?", cfg.Profile, cmd.CommandPath()))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		cmd.SilenceUsage = true
+		return fmt.Errorf("aborted, no changes were made")
+	}
+	return nil
+}
+
+// confirmChanges asks before a command applies changes, unless the user
+// passed --yes. Profiles requiring confirmation are always asked.
+func confirmChanges(cmd *cobra.Command, cfg *config.Config, yes bool, prompt string) (bool, error) {
+	if yes && !cfg.ConfirmMutations {
+		return true, nil
+	}
+	return confirm(cmd, prompt)
+}
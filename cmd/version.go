@@ -1,6 +1,8 @@
 package cmd
 
 import (
+	"fmt"
+
 	"github.com/rossi1/ensync-cli/pkg/version"
 	"github.com/spf13/cobra"
 )
@@ -15,6 +17,7 @@ func newVersionCmd() *cobra.Command {
 			if jsonFormat {
 				return printJSON(cmd.OutOrStdout(), version.Get())
 			}
+			fmt.Fprintln(cmd.OutOrStdout(), version.String())
 			return nil
 		},
 	}
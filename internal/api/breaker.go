@@ -0,0 +1,209 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ErrCircuitOpen is returned without sending the request while the circuit
+// breaker is open, after the API failed repeatedly
+var ErrCircuitOpen = errors.New("circuit breaker is open: the EnSync API keeps failing, request not sent")
+
+// CircuitState is the state of a circuit breaker
+type CircuitState int
+
+const (
+	// CircuitClosed sends every request
+	CircuitClosed CircuitState = iota
+	// CircuitOpen fails every request fast until the cool-down is over
+	CircuitOpen
+	// CircuitHalfOpen lets a single trial request through
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// BreakerConfig configures a circuit breaker. Zero values keep the defaults
+// of DefaultBreakerConfig.
+type BreakerConfig struct {
+	// Failures is the number of consecutive failures opening the circuit
+	Failures int
+	// Window is the time within which the failures must happen
+	Window time.Duration
+	// CoolDown is the time the circuit stays open before a trial request
+	CoolDown time.Duration
+}
+
+// DefaultBreakerConfig returns the configuration used for zero values
+func DefaultBreakerConfig() BreakerConfig {
+	return BreakerConfig{
+		Failures: 5,
+		Window:   30 * time.Second,
+		CoolDown: 30 * time.Second,
+	}
+}
+
+// BreakerStats summarizes what a circuit breaker did
+type BreakerStats struct {
+	State CircuitState
+	// Opened counts the times the circuit opened
+	Opened int
+	// Rejected counts the requests failed fast
+	Rejected int
+}
+
+// CircuitBreaker stops sending requests after repeated failures: connection
+// errors and 5xx responses. Rate limiting (429) is not a failure.
+type CircuitBreaker struct {
+	config BreakerConfig
+	logger *zap.Logger
+
+	mu       sync.Mutex
+	state    CircuitState
+	failures int
+	// firstFailure starts the current run of consecutive failures
+	firstFailure time.Time
+	openedAt     time.Time
+	// trial is set while the trial request of the half-open state is sent
+	trial bool
+	stats BreakerStats
+}
+
+// NewCircuitBreaker returns a closed circuit breaker
+func NewCircuitBreaker(config BreakerConfig, logger *zap.Logger) *CircuitBreaker {
+	defaults := DefaultBreakerConfig()
+	if config.Failures <= 0 {
+		config.Failures = defaults.Failures
+	}
+	if config.Window <= 0 {
+		config.Window = defaults.Window
+	}
+	if config.CoolDown <= 0 {
+		config.CoolDown = defaults.CoolDown
+	}
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	return &CircuitBreaker{config: config, logger: logger}
+}
+
+// Stats returns the state of the breaker and what it did so far
+func (b *CircuitBreaker) Stats() BreakerStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	stats := b.stats
+	stats.State = b.state
+	return stats
+}
+
+// allow reports whether a request may be sent, and whether it is the trial
+// request of the half-open state
+func (b *CircuitBreaker) allow() (bool, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case CircuitOpen:
+		if time.Since(b.openedAt) < b.config.CoolDown {
+			b.stats.Rejected++
+			return false, false
+		}
+		b.state = CircuitHalfOpen
+		b.logger.Debug("Circuit breaker half-open, sending a trial request")
+		fallthrough
+	case CircuitHalfOpen:
+		if b.trial {
+			b.stats.Rejected++
+			return false, false
+		}
+		b.trial = true
+		return true, true
+	default:
+		return true, false
+	}
+}
+
+// release ends a request without recording its outcome
+func (b *CircuitBreaker) release(trial bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if trial {
+		b.trial = false
+	}
+}
+
+// record updates the breaker with the outcome of a request
+func (b *CircuitBreaker) record(trial, failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if trial {
+		b.trial = false
+	}
+
+	if !failed {
+		if b.state != CircuitClosed {
+			b.logger.Debug("Circuit breaker closed, the trial request succeeded")
+		}
+		b.state = CircuitClosed
+		b.failures = 0
+		return
+	}
+
+	now := time.Now()
+	if b.failures == 0 || now.Sub(b.firstFailure) > b.config.Window {
+		b.failures = 0
+		b.firstFailure = now
+	}
+	b.failures++
+
+	if trial || (b.state == CircuitClosed && b.failures >= b.config.Failures) {
+		b.state = CircuitOpen
+		b.openedAt = now
+		b.stats.Opened++
+		b.logger.Debug("Circuit breaker opened",
+			zap.Int("consecutive_failures", b.failures),
+			zap.Bool("trial", trial),
+			zap.Duration("cool_down", b.config.CoolDown),
+		)
+	}
+}
+
+// breakerTransport sends requests through the circuit breaker
+type breakerTransport struct {
+	next    http.RoundTripper
+	breaker *CircuitBreaker
+}
+
+func (t *breakerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	allowed, trial := t.breaker.allow()
+	if !allowed {
+		return nil, ErrCircuitOpen
+	}
+
+	resp, err := t.next.RoundTrip(req)
+
+	// A request cancelled by the caller says nothing about the server
+	if err != nil && errors.Is(req.Context().Err(), context.Canceled) {
+		t.breaker.release(trial)
+		return resp, err
+	}
+
+	failed := err != nil || resp.StatusCode >= http.StatusInternalServerError
+	t.breaker.record(trial, failed)
+	return resp, err
+}
@@ -4,54 +4,182 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
-	"time"
 
 	"github.com/hashicorp/go-retryablehttp"
 	"go.uber.org/zap"
-	"golang.org/x/time/rate"
 
 	"github.com/rossi1/ensync-cli/internal/domain"
+	"github.com/rossi1/ensync-cli/internal/platform/ratelimit"
 )
 
 type Client struct {
 	baseURL     string
 	apiKey      string
+	credentials CredentialSource
 	httpClient  *http.Client
-	rateLimiter *rate.Limiter
-	logger      *zap.Logger
+	retryPolicy RetryPolicy
+	rateLimiter *ratelimit.Limiter
+	rateBucket  BucketFunc
+	breaker     *CircuitBreaker
+	// breakerConfig is set by WithCircuitBreaker, the breaker is created
+	// once the logger is known
+	breakerConfig *BreakerConfig
+	logger        *zap.Logger
+
+	// transport is the innermost transport, used by the TLS and connection
+	// options. It is nil with WithCustomHTTPClient.
+	transport       *http.Transport
+	tlsConfig       TLSConfig
+	transportConfig TransportConfig
+	dialer          DialFunc
+	// socket is the Unix domain socket of a unix:// base URL
+	socket string
+	// middlewares wrap the transport of httpClient, the first one outermost
+	middlewares []Middleware
+	// err holds an invalid option, returned by every request
+	err error
+}
+
+// CredentialSource supplies the API key for every request, and a new one
+// when the server rejects the current one
+type CredentialSource interface {
+	APIKey(ctx context.Context) (string, error)
+	Refresh(ctx context.Context) (string, error)
 }
 
 func NewClient(baseURL, apiKey string, opts ...ClientOption) *Client {
 	retryClient := retryablehttp.NewClient()
-	retryClient.RetryMax = 3
-	retryClient.RetryWaitMin = 1 * time.Second
-	retryClient.RetryWaitMax = 5 * time.Second
 
 	c := &Client{
-		baseURL:    baseURL,
-		apiKey:     apiKey,
-		httpClient: retryClient.StandardClient(),
-		logger:     zap.NewNop(),
+		baseURL:     baseURL,
+		apiKey:      apiKey,
+		httpClient:  retryClient.StandardClient(),
+		retryPolicy: DefaultRetryPolicy(),
+		logger:      zap.NewNop(),
+	}
+	c.transport, _ = retryClient.HTTPClient.Transport.(*http.Transport)
+
+	if socket, path, ok := parseUnixURL(baseURL); ok {
+		c.socket = socket
+		c.baseURL = "http://" + unixHost + path
 	}
 
 	for _, opt := range opts {
 		opt(c)
 	}
 
+	c.retryPolicy = c.retryPolicy.withDefaults()
+	c.retryPolicy.apply(retryClient, c.logger)
+	c.err = c.configureTransport()
+
+	if c.rateLimiter != nil {
+		bucket := c.rateBucket
+		if bucket == nil {
+			bucket = defaultBucket
+		}
+		c.wrapAttempts(retryClient, func(next http.RoundTripper) http.RoundTripper {
+			return &rateLimitTransport{next: next, limiter: c.rateLimiter, bucket: bucket}
+		})
+	}
+
+	// The breaker sits above the retries, so that a call counts as one
+	// failure however many attempts it made, and above the rate limiter, so
+	// that requests it rejects do not wait for a token
+	if c.breakerConfig != nil {
+		c.breaker = NewCircuitBreaker(*c.breakerConfig, c.logger)
+		c.wrapCalls(func(next http.RoundTripper) http.RoundTripper {
+			return &breakerTransport{next: next, breaker: c.breaker}
+		})
+	}
+
+	if len(c.middlewares) > 0 {
+		c.wrapCalls(func(next http.RoundTripper) http.RoundTripper {
+			return chain(next, c.middlewares)
+		})
+	}
+
 	return c
 }
 
+// wrapCalls wraps the transport every call goes through once, above the
+// retries
+func (c *Client) wrapCalls(middleware Middleware) {
+	// Copy the client rather than change one given to WithCustomHTTPClient
+	httpClient := *c.httpClient
+	transport := httpClient.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	httpClient.Transport = middleware(transport)
+	c.httpClient = &httpClient
+}
+
+// wrapAttempts wraps the transport every attempt of a request goes through:
+// the one below the retries, or the transport of a custom HTTP client
+func (c *Client) wrapAttempts(retryClient *retryablehttp.Client, middleware Middleware) {
+	if c.transport != nil {
+		retryClient.HTTPClient.Transport = middleware(retryClient.HTTPClient.Transport)
+		return
+	}
+	c.wrapCalls(middleware)
+}
+
+// CircuitBreaker returns the circuit breaker of the client, nil without
+// WithCircuitBreaker
+func (c *Client) CircuitBreaker() *CircuitBreaker {
+	return c.breaker
+}
+
+// configureTransport applies the connection and TLS options to the transport
+func (c *Client) configureTransport() error {
+	if c.socket == "" && c.dialer == nil && c.transportConfig.IsZero() && c.tlsConfig.IsZero() {
+		return nil
+	}
+	if c.transport == nil {
+		return fmt.Errorf("connection options cannot be combined with a custom HTTP client")
+	}
+
+	if err := c.transportConfig.apply(c.transport); err != nil {
+		return fmt.Errorf("invalid connection configuration: %w", err)
+	}
+
+	if c.dialer != nil {
+		c.transport.DialContext = c.dialer
+	}
+	if c.socket != "" {
+		// A proxy cannot reach a local socket
+		c.transport.DialContext = unixDialer(c.socket)
+		c.transport.Proxy = nil
+	}
+
+	if c.tlsConfig.IsZero() {
+		return nil
+	}
+	return c.configureTLS()
+}
+
+func (c *Client) configureTLS() error {
+	tlsConfig, err := c.tlsConfig.Build()
+	if err != nil {
+		return fmt.Errorf("invalid TLS configuration: %w", err)
+	}
+
+	if c.tlsConfig.InsecureSkipVerify {
+		c.logger.Warn("TLS certificate verification is disabled, connections can be intercepted")
+	}
+
+	c.transport.TLSClientConfig = tlsConfig
+	return nil
+}
+
 func (c *Client) doRequest(ctx context.Context, method, path string, query url.Values, body interface{}) ([]byte, error) {
-	// Handle rate limiting
-	if c.rateLimiter != nil {
-		err := c.rateLimiter.Wait(ctx)
-		if err != nil {
-			return nil, fmt.Errorf("rate limit error: %w", err)
-		}
+	if c.err != nil {
+		return nil, c.err
 	}
 
 	// Prepare request URL
@@ -61,24 +189,68 @@ func (c *Client) doRequest(ctx context.Context, method, path string, query url.V
 	}
 
 	// Marshal body if present
-	var bodyReader io.Reader
+	var bodyBytes []byte
 	if body != nil {
-		bodyBytes, err := json.Marshal(body)
+		var err error
+		bodyBytes, err = json.Marshal(body)
 		if err != nil {
 			return nil, fmt.Errorf("failed to marshal request body: %w", err)
 		}
+	}
+
+	// One key per call, shared by its retries and by the resend after a
+	// credential refresh
+	if needsIdempotencyKey(method) && IdempotencyKey(ctx) == "" {
+		key, err := NewIdempotencyKey()
+		if err != nil {
+			return nil, err
+		}
+		ctx = UseIdempotencyKey(ctx, key)
+	}
+
+	apiKey := c.apiKey
+	if c.credentials != nil {
+		var err error
+		apiKey, err = c.credentials.APIKey(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get API key: %w", err)
+		}
+	}
+
+	respBody, err := c.send(ctx, method, path, reqURL, bodyBytes, apiKey)
+	if c.credentials == nil || !IsUnauthorized(err) {
+		return respBody, err
+	}
+
+	// The key may have been revoked or rotated, ask for a new one and try
+	// once more
+	c.logger.Debug("API key rejected, refreshing credentials")
+	apiKey, refreshErr := c.credentials.Refresh(ctx)
+	if refreshErr != nil {
+		return nil, fmt.Errorf("failed to refresh API key: %w", refreshErr)
+	}
+	return c.send(ctx, method, path, reqURL, bodyBytes, apiKey)
+}
+
+// send performs a single request with the given API key
+func (c *Client) send(ctx context.Context, method, path, reqURL string, bodyBytes []byte, apiKey string) ([]byte, error) {
+	var bodyReader io.Reader
+	if bodyBytes != nil {
 		bodyReader = bytes.NewReader(bodyBytes)
 	}
 
 	// Create request
-	req, err := http.NewRequestWithContext(ctx, method, reqURL, bodyReader)
+	req, err := http.NewRequestWithContext(c.retryPolicy.allow(ctx, method), method, reqURL, bodyReader)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	// Set headers
-	req.Header.Set(XAPIHeader, c.apiKey)
-	if body != nil {
+	req.Header.Set(XAPIHeader, apiKey)
+	if needsIdempotencyKey(method) {
+		req.Header.Set(IdempotencyKeyHeader, IdempotencyKey(ctx))
+	}
+	if bodyBytes != nil {
 		req.Header.Set("Content-Type", ContentTypeHeader)
 	}
 	req.Header.Set("Accept", ContentTypeHeader)
@@ -92,6 +264,9 @@ func (c *Client) doRequest(ctx context.Context, method, path string, query url.V
 
 	// Execute request with timeout
 	resp, err := c.httpClient.Do(req)
+	if errors.Is(err, ErrCircuitOpen) {
+		return nil, ErrCircuitOpen
+	}
 	if err != nil {
 		return nil, fmt.Errorf("request failed: %w", err)
 	}
@@ -244,7 +419,11 @@ func (c *Client) GetAccessKeyPermissions(ctx context.Context, key string) (*doma
 	return response, nil
 }
 
+// SetAccessKeyPermissions replaces the permissions of a key. The request is
+// a POST but setting the same permissions twice has the same result, so it
+// is retried like an idempotent request.
 func (c *Client) SetAccessKeyPermissions(ctx context.Context, key string, permissions *domain.Permissions) error {
+	ctx = idempotent(ctx)
 	url := fmt.Sprintf("/access-key/permissions/%s", key)
 
 	payload := map[string]interface{}{
@@ -259,3 +438,45 @@ func (c *Client) SetAccessKeyPermissions(ctx context.Context, key string, permis
 
 	return nil
 }
+
+func (c *Client) ListAccessKeyMetadata(ctx context.Context) (map[string]*domain.AccessKeyMetadata, error) {
+	data, err := c.doRequest(ctx, http.MethodGet, "/access-key/metadata", nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list access key metadata: %w", err)
+	}
+
+	var response struct {
+		Results map[string]*domain.AccessKeyMetadata `json:"results"`
+	}
+	if err := json.Unmarshal(data, &response); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	return response.Results, nil
+}
+
+func (c *Client) SetAccessKeyMetadata(ctx context.Context, key string, metadata *domain.AccessKeyMetadata) error {
+	encodedName := url.PathEscape(key)
+
+	url := fmt.Sprintf("/access-key/metadata/%s", encodedName)
+
+	_, err := c.doRequest(ctx, http.MethodPut, url, nil, metadata)
+	if err != nil {
+		return fmt.Errorf("failed to set access key metadata: %w", err)
+	}
+
+	return nil
+}
+
+func (c *Client) DeleteAccessKeyMetadata(ctx context.Context, key string) error {
+	encodedName := url.PathEscape(key)
+
+	url := fmt.Sprintf("/access-key/metadata/%s", encodedName)
+
+	_, err := c.doRequest(ctx, http.MethodDelete, url, nil, nil)
+	if err != nil {
+		return fmt.Errorf("failed to delete access key metadata: %w", err)
+	}
+
+	return nil
+}
@@ -5,7 +5,8 @@ import (
 	"time"
 
 	"go.uber.org/zap"
-	"golang.org/x/time/rate"
+
+	"github.com/rossi1/ensync-cli/internal/platform/ratelimit"
 )
 
 type ClientOption func(*Client)
@@ -22,14 +23,134 @@ func WithTimeout(timeout time.Duration) ClientOption {
 	}
 }
 
+// WithRateLimit sends at most rps requests per second, reads and writes
+// alike, after a burst of burst requests. The requests are paused when the
+// server answers 429.
 func WithRateLimit(rps float64, burst int) ClientOption {
 	return func(c *Client) {
-		c.rateLimiter = rate.NewLimiter(rate.Limit(rps), burst)
+		c.rateLimiter = ratelimit.New(ratelimit.Config{Default: ratelimit.Limits{Rate: rps, Burst: burst}})
+	}
+}
+
+// WithRateLimiter waits for limiter before every attempt of a request, in the
+// bucket picked by bucket, or by ratelimit.BucketFor when it is nil
+func WithRateLimiter(limiter *ratelimit.Limiter, bucket BucketFunc) ClientOption {
+	return func(c *Client) {
+		c.rateLimiter = limiter
+		c.rateBucket = bucket
 	}
 }
 
+// WithCustomHTTPClient replaces the HTTP client, including retries. The TLS
+// and connection options cannot be used with it, configure the client's
+// transport instead, and the retry policy is ignored.
 func WithCustomHTTPClient(httpClient *http.Client) ClientOption {
 	return func(c *Client) {
 		c.httpClient = httpClient
+		c.transport = nil
+	}
+}
+
+// WithCredentials takes the API key from source instead of the fixed key
+// given to NewClient, and refreshes it once when a request gets a 401
+func WithCredentials(source CredentialSource) ClientOption {
+	return func(c *Client) {
+		c.credentials = source
+	}
+}
+
+// WithTLS sets all TLS settings at once, replacing earlier TLS options
+func WithTLS(config TLSConfig) ClientOption {
+	return func(c *Client) {
+		c.tlsConfig = config
+	}
+}
+
+// WithCAFile trusts the certificates of a PEM bundle in addition to the
+// system roots
+func WithCAFile(path string) ClientOption {
+	return func(c *Client) {
+		c.tlsConfig.CAFile = path
+	}
+}
+
+// WithClientCertificate presents a client certificate for mutual TLS
+func WithClientCertificate(certFile, keyFile string) ClientOption {
+	return func(c *Client) {
+		c.tlsConfig.CertFile = certFile
+		c.tlsConfig.KeyFile = keyFile
+	}
+}
+
+// WithServerName checks the server certificate against name instead of the
+// host of the base URL
+func WithServerName(name string) ClientOption {
+	return func(c *Client) {
+		c.tlsConfig.ServerName = name
+	}
+}
+
+// WithPinnedSPKI only accepts servers whose certificate chain contains one of
+// the public keys, given as base64 SHA-256 hashes of the SubjectPublicKeyInfo
+func WithPinnedSPKI(pins ...string) ClientOption {
+	return func(c *Client) {
+		c.tlsConfig.PinnedSPKI = append(c.tlsConfig.PinnedSPKI, pins...)
+	}
+}
+
+// WithInsecureSkipVerify disables server certificate verification. Only use
+// it against test servers.
+func WithInsecureSkipVerify() ClientOption {
+	return func(c *Client) {
+		c.tlsConfig.InsecureSkipVerify = true
+	}
+}
+
+// WithTransport sets the proxy and connection pool settings
+func WithTransport(config TransportConfig) ClientOption {
+	return func(c *Client) {
+		c.transportConfig = config
+	}
+}
+
+// WithProxy sends requests through an http, https or socks5 proxy, except to
+// the hosts of noProxy, a comma-separated list in the format of NO_PROXY
+func WithProxy(proxyURL, noProxy string) ClientOption {
+	return func(c *Client) {
+		c.transportConfig.ProxyURL = proxyURL
+		c.transportConfig.NoProxy = noProxy
+	}
+}
+
+// WithDialer opens connections with dial instead of a TCP dialer. It is
+// ignored for unix:// base URLs.
+func WithDialer(dial DialFunc) ClientOption {
+	return func(c *Client) {
+		c.dialer = dial
+	}
+}
+
+// WithMiddleware wraps the transport of the client in middlewares, the first
+// one outermost. Middlewares run around the retries, and can be given by
+// several options.
+func WithMiddleware(middlewares ...Middleware) ClientOption {
+	return func(c *Client) {
+		c.middlewares = append(c.middlewares, middlewares...)
+	}
+}
+
+// WithRetryPolicy replaces the default retry policy. Settings left zero keep
+// their default.
+func WithRetryPolicy(policy RetryPolicy) ClientOption {
+	return func(c *Client) {
+		c.retryPolicy = policy
+	}
+}
+
+// WithCircuitBreaker fails requests fast with ErrCircuitOpen after repeated
+// failures, until a trial request succeeds after the cool-down
+func WithCircuitBreaker(config BreakerConfig) ClientOption {
+	return func(c *Client) {
+		c.breakerConfig = &config
 	}
 }
@@ -0,0 +1,52 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+)
+
+// IdempotencyKeyHeader lets the server recognize repeated POST and PUT
+// requests, so that it can answer them without applying them twice
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+type idempotencyKey struct{}
+
+// UseIdempotencyKey returns a context sending key as the Idempotency-Key of
+// the POST and PUT requests made with it. Without it every call of the
+// client gets a key of its own.
+func UseIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, idempotencyKey{}, key)
+}
+
+// IdempotencyKey returns the key UseIdempotencyKey set on ctx, if any
+func IdempotencyKey(ctx context.Context) string {
+	key, _ := ctx.Value(idempotencyKey{}).(string)
+	return key
+}
+
+// NewIdempotencyKey returns a random UUID
+func NewIdempotencyKey() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate idempotency key: %w", err)
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+// needsIdempotencyKey reports whether requests with method carry a key
+func needsIdempotencyKey(method string) bool {
+	return method == http.MethodPost || method == http.MethodPut
+}
+
+// IsTimeout reports whether err is a request that timed out, whose outcome
+// is unknown: the server may or may not have applied it
+func IsTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
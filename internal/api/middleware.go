@@ -1,19 +1,45 @@
 package api
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"net/http"
 	"time"
 
 	"go.uber.org/zap"
 )
 
+// RequestIDHeader carries the ID of a request, set by the request ID
+// middleware
+const RequestIDHeader = "X-Request-ID"
+
+// Middleware wraps the transport of the client. Middlewares installed with
+// WithMiddleware run around the retries, once per call of the client.
 type Middleware func(next http.RoundTripper) http.RoundTripper
 
+// RoundTripperFunc adapts a function to http.RoundTripper, to write
+// middlewares without a type of their own
+type RoundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f RoundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// chain wraps transport in the middlewares, the first one outermost
+func chain(transport http.RoundTripper, middlewares []Middleware) http.RoundTripper {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		transport = middlewares[i](transport)
+	}
+	return transport
+}
+
 type loggingTransport struct {
 	next   http.RoundTripper
 	logger *zap.Logger
 }
 
+// NewLoggingMiddleware logs every request at debug level with its status,
+// or its error, and duration
 func NewLoggingMiddleware(logger *zap.Logger) Middleware {
 	return func(next http.RoundTripper) http.RoundTripper {
 		return &loggingTransport{
@@ -28,14 +54,81 @@ func (t *loggingTransport) RoundTrip(req *http.Request) (*http.Response, error)
 
 	resp, err := t.next.RoundTrip(req)
 
-	duration := time.Since(start)
-
-	t.logger.Info("API Request",
+	fields := []zap.Field{
 		zap.String("method", req.Method),
 		zap.String("url", req.URL.String()),
-		zap.Duration("duration", duration),
-		zap.Int("status", resp.StatusCode),
-	)
+		zap.Duration("duration", time.Since(start)),
+	}
+	if id := req.Header.Get(RequestIDHeader); id != "" {
+		fields = append(fields, zap.String("request_id", id))
+	}
+	if err != nil {
+		t.logger.Debug("API request failed", append(fields, zap.Error(err))...)
+		return resp, err
+	}
+
+	t.logger.Debug("API request", append(fields, zap.Int("status", resp.StatusCode))...)
+	return resp, nil
+}
+
+// NewHeaderMiddleware adds headers to every request, replacing values the
+// request already has
+func NewHeaderMiddleware(headers http.Header) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			// A RoundTripper must not modify the request it is given
+			req = req.Clone(req.Context())
+			for name, values := range headers {
+				req.Header.Del(name)
+				for _, value := range values {
+					req.Header.Add(name, value)
+				}
+			}
+			return next.RoundTrip(req)
+		})
+	}
+}
+
+// NewRequestIDMiddleware sets a random X-Request-ID on requests without one.
+// Retries of a request keep its ID.
+func NewRequestIDMiddleware() Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			if req.Header.Get(RequestIDHeader) != "" {
+				return next.RoundTrip(req)
+			}
+
+			id, err := newRequestID()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Header.Set(RequestIDHeader, id)
+			return next.RoundTrip(req)
+		})
+	}
+}
+
+func newRequestID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
 
-	return resp, err
+// TimingFunc receives the duration of a request, with its response or error
+type TimingFunc func(req *http.Request, resp *http.Response, err error, duration time.Duration)
+
+// NewTimingMiddleware reports the duration of every request to observe, such
+// as to record metrics
+func NewTimingMiddleware(observe TimingFunc) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			start := time.Now()
+			resp, err := next.RoundTrip(req)
+			observe(req, resp, err, time.Since(start))
+			return resp, err
+		})
+	}
 }
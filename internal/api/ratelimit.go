@@ -0,0 +1,35 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/rossi1/ensync-cli/internal/platform/ratelimit"
+)
+
+// BucketFunc picks the rate limit bucket of a request
+type BucketFunc func(req *http.Request) ratelimit.Bucket
+
+// defaultBucket separates reads from writes
+func defaultBucket(req *http.Request) ratelimit.Bucket {
+	return ratelimit.BucketFor(req.Method)
+}
+
+// rateLimitTransport waits for the limiter before every attempt, retries
+// included, and reports the responses back to it
+type rateLimitTransport struct {
+	next    http.RoundTripper
+	limiter *ratelimit.Limiter
+	bucket  BucketFunc
+}
+
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	bucket := t.bucket(req)
+	if err := t.limiter.Wait(req.Context(), bucket); err != nil {
+		return nil, fmt.Errorf("rate limit error: %w", err)
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	t.limiter.Observe(bucket, resp)
+	return resp, err
+}
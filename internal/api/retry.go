@@ -1 +1,205 @@
 package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-retryablehttp"
+	"go.uber.org/zap"
+)
+
+// RetryPolicy decides which requests are retried and how long to wait
+// between attempts
+type RetryPolicy struct {
+	// MaxAttempts is the number of attempts of a request, including the
+	// first one. 1 disables retries.
+	MaxAttempts int
+	// MinWait and MaxWait bound the exponential backoff. The wait before
+	// each retry is picked at random between half and all of the backoff.
+	MinWait time.Duration
+	MaxWait time.Duration
+	// MaxRetryAfter is the longest Retry-After the client waits for. The
+	// response is returned when the server asks for a longer wait.
+	MaxRetryAfter time.Duration
+	// RetryNonIdempotent also retries POST, PUT, PATCH and other requests
+	// that may not be safe to repeat. ForceRetry and NoRetry override it per call.
+	RetryNonIdempotent bool
+}
+
+// DefaultRetryPolicy returns the policy of clients without WithRetryPolicy
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:   4,
+		MinWait:       1 * time.Second,
+		MaxWait:       5 * time.Second,
+		MaxRetryAfter: 1 * time.Minute,
+	}
+}
+
+// withDefaults fills in the settings left zero
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	defaults := DefaultRetryPolicy()
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = defaults.MaxAttempts
+	}
+	if p.MinWait <= 0 {
+		p.MinWait = defaults.MinWait
+	}
+	if p.MaxWait < p.MinWait {
+		p.MaxWait = max(defaults.MaxWait, p.MinWait)
+	}
+	if p.MaxRetryAfter <= 0 {
+		p.MaxRetryAfter = defaults.MaxRetryAfter
+	}
+	return p
+}
+
+// idempotentMethods are retried by default. RFC 9110 section 9.2.2 also
+// lists PUT, but the server does not promise that repeating one is harmless,
+// so PUT requests opt in per call with idempotent.
+var idempotentMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodOptions: true,
+	http.MethodTrace:   true,
+	http.MethodDelete:  true,
+}
+
+type retryKey struct{}
+
+// ForceRetry returns a context retrying requests made with it, even when
+// their method is not idempotent
+func ForceRetry(ctx context.Context) context.Context {
+	return context.WithValue(ctx, retryKey{}, true)
+}
+
+// NoRetry returns a context making a single attempt of requests made with it
+func NoRetry(ctx context.Context) context.Context {
+	return context.WithValue(ctx, retryKey{}, false)
+}
+
+// idempotent marks a request as safe to repeat, despite its method, unless
+// the caller chose with ForceRetry or NoRetry
+func idempotent(ctx context.Context) context.Context {
+	if _, ok := ctx.Value(retryKey{}).(bool); ok {
+		return ctx
+	}
+	return ForceRetry(ctx)
+}
+
+type retryAllowedKey struct{}
+
+// allow returns the request context, recording whether the request may be
+// retried for checkRetry
+func (p RetryPolicy) allow(ctx context.Context, method string) context.Context {
+	allowed, ok := ctx.Value(retryKey{}).(bool)
+	if !ok {
+		allowed = p.RetryNonIdempotent || idempotentMethods[method]
+	}
+	return context.WithValue(ctx, retryAllowedKey{}, allowed)
+}
+
+// apply configures the retry client with the policy
+func (p RetryPolicy) apply(client *retryablehttp.Client, logger *zap.Logger) {
+	client.RetryMax = p.MaxAttempts - 1
+	client.RetryWaitMin = p.MinWait
+	client.RetryWaitMax = p.MaxWait
+	client.CheckRetry = p.checkRetry
+	client.Backoff = p.backoff
+	client.ErrorHandler = giveUp
+	client.Logger = retryLogger{logger}
+	client.RequestLogHook = func(_ retryablehttp.Logger, req *http.Request, retry int) {
+		logger.Debug("Attempting request",
+			zap.String("method", req.Method),
+			zap.String("url", req.URL.Redacted()),
+			zap.Int("attempt", retry+1),
+			zap.Int("max_attempts", p.MaxAttempts),
+		)
+	}
+}
+
+func (p RetryPolicy) checkRetry(ctx context.Context, resp *http.Response, err error) (bool, error) {
+	if allowed, ok := ctx.Value(retryAllowedKey{}).(bool); ok && !allowed {
+		return false, ctx.Err()
+	}
+	if errors.Is(err, ErrCircuitOpen) {
+		return false, nil
+	}
+
+	retry, checkErr := retryablehttp.DefaultRetryPolicy(ctx, resp, err)
+	if !retry || checkErr != nil {
+		return retry, checkErr
+	}
+
+	// Rather return the response than keep the command waiting for long
+	if wait, ok := retryAfter(resp); ok && wait > p.MaxRetryAfter {
+		return false, nil
+	}
+	return true, nil
+}
+
+// backoff waits for Retry-After when the server sends it, and otherwise for
+// an exponential backoff with jitter
+func (p RetryPolicy) backoff(minWait, maxWait time.Duration, retry int, resp *http.Response) time.Duration {
+	if wait, ok := retryAfter(resp); ok {
+		return wait
+	}
+
+	wait := float64(minWait) * math.Pow(2, float64(retry))
+	if wait > float64(maxWait) {
+		wait = float64(maxWait)
+	}
+	half := int64(wait / 2)
+	return time.Duration(half + rand.Int64N(half+1))
+}
+
+// retryAfter returns the wait the server asks for in the Retry-After header
+// of a 429 or 503 response, given in seconds or as a date
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	if resp == nil || (resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable) {
+		return 0, false
+	}
+
+	value := strings.TrimSpace(resp.Header.Get("Retry-After"))
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(max(seconds, 0)) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(time.Until(date), 0), true
+	}
+	return 0, false
+}
+
+// giveUp returns the last response once the retries are exhausted, so that
+// the error of the server is reported rather than the number of attempts
+func giveUp(resp *http.Response, err error, attempts int) (*http.Response, error) {
+	if resp != nil {
+		return resp, nil
+	}
+	return nil, fmt.Errorf("giving up after %d attempt(s): %w", attempts, err)
+}
+
+// retryLogger routes the logs of the retry client to zap at debug level, the
+// client reports failures itself
+type retryLogger struct {
+	logger *zap.Logger
+}
+
+func (l retryLogger) log(msg string, keysAndValues []interface{}) {
+	l.logger.WithOptions(zap.AddCallerSkip(2)).Sugar().Debugw(msg, keysAndValues...)
+}
+
+func (l retryLogger) Error(msg string, keysAndValues ...interface{}) { l.log(msg, keysAndValues) }
+func (l retryLogger) Info(msg string, keysAndValues ...interface{})  { l.log(msg, keysAndValues) }
+func (l retryLogger) Debug(msg string, keysAndValues ...interface{}) { l.log(msg, keysAndValues) }
+func (l retryLogger) Warn(msg string, keysAndValues ...interface{})  { l.log(msg, keysAndValues) }
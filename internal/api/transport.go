@@ -0,0 +1,191 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// UnixScheme prefixes base URLs reaching the server through a Unix domain
+// socket, such as unix:///run/ensync.sock. An HTTP path can follow the
+// socket after a colon: unix:///run/ensync.sock:/api/v1/ensync.
+const UnixScheme = "unix://"
+
+// unixHost is the host of requests sent over a Unix domain socket. It only
+// appears in the Host header.
+const unixHost = "unix"
+
+// DialFunc opens the connections of the client
+type DialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// TransportConfig holds the connection settings of the client. Zero values
+// keep the defaults of the transport.
+type TransportConfig struct {
+	// ProxyURL is the http, https or socks5 proxy of every request. Without
+	// it the HTTP_PROXY, HTTPS_PROXY and NO_PROXY variables are used.
+	ProxyURL string
+	// NoProxy lists hosts reached directly, comma-separated in the format of
+	// NO_PROXY: host names, domain suffixes like .example.com, IP addresses,
+	// CIDR ranges, any of them with a port, or * for every host
+	NoProxy string
+
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+	MaxConnsPerHost     int
+	IdleConnTimeout     time.Duration
+}
+
+// IsZero reports whether no connection setting was given
+func (c TransportConfig) IsZero() bool {
+	return c == TransportConfig{}
+}
+
+// apply configures the transport with the settings
+func (c TransportConfig) apply(transport *http.Transport) error {
+	if c.ProxyURL != "" || c.NoProxy != "" {
+		proxy, err := c.proxy()
+		if err != nil {
+			return err
+		}
+		transport.Proxy = proxy
+	}
+
+	if c.MaxIdleConns > 0 {
+		transport.MaxIdleConns = c.MaxIdleConns
+	}
+	if c.MaxIdleConnsPerHost > 0 {
+		transport.MaxIdleConnsPerHost = c.MaxIdleConnsPerHost
+	}
+	if c.MaxConnsPerHost > 0 {
+		transport.MaxConnsPerHost = c.MaxConnsPerHost
+	}
+	if c.IdleConnTimeout > 0 {
+		transport.IdleConnTimeout = c.IdleConnTimeout
+	}
+	return nil
+}
+
+// proxy returns the proxy function of the transport
+func (c TransportConfig) proxy() (func(*http.Request) (*url.URL, error), error) {
+	proxy := http.ProxyFromEnvironment
+	if c.ProxyURL != "" {
+		proxyURL, err := url.Parse(c.ProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy URL: %w", err)
+		}
+		switch proxyURL.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return nil, fmt.Errorf("invalid proxy URL %s: the scheme must be http, https, socks5 or socks5h", c.ProxyURL)
+		}
+		if proxyURL.Host == "" {
+			return nil, fmt.Errorf("invalid proxy URL %s: missing host", c.ProxyURL)
+		}
+		proxy = http.ProxyURL(proxyURL)
+	}
+
+	noProxy := parseNoProxy(c.NoProxy)
+	return func(req *http.Request) (*url.URL, error) {
+		if noProxy.match(req.URL) {
+			return nil, nil
+		}
+		return proxy(req)
+	}, nil
+}
+
+// noProxyList holds the parsed entries of a NO_PROXY list
+type noProxyList struct {
+	all      bool
+	networks []*net.IPNet
+	hosts    []noProxyHost
+}
+
+type noProxyHost struct {
+	// name is a host name or IP address, or a domain suffix when it starts
+	// with a dot
+	name string
+	// port is empty to match any port
+	port string
+}
+
+func parseNoProxy(value string) noProxyList {
+	var list noProxyList
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+		if entry == "*" {
+			list.all = true
+			continue
+		}
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			list.networks = append(list.networks, network)
+			continue
+		}
+
+		host, port := entry, ""
+		if h, p, err := net.SplitHostPort(entry); err == nil {
+			host, port = h, p
+		}
+		list.hosts = append(list.hosts, noProxyHost{name: strings.Trim(host, "[]"), port: port})
+	}
+	return list
+}
+
+// match reports whether requests to u bypass the proxy
+func (l noProxyList) match(u *url.URL) bool {
+	if l.all {
+		return true
+	}
+
+	host := strings.ToLower(u.Hostname())
+	port := u.Port()
+	if port == "" {
+		port = map[string]string{"http": "80", "https": "443"}[u.Scheme]
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		for _, network := range l.networks {
+			if network.Contains(ip) {
+				return true
+			}
+		}
+	}
+
+	for _, entry := range l.hosts {
+		if entry.port != "" && entry.port != port {
+			continue
+		}
+		// Like curl, example.com and .example.com both match the domain and
+		// its subdomains
+		name := strings.TrimPrefix(entry.name, ".")
+		if host == name || strings.HasSuffix(host, "."+name) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseUnixURL splits a unix:// base URL into the socket path and the HTTP
+// path of the API
+func parseUnixURL(baseURL string) (socket, path string, ok bool) {
+	rest, ok := strings.CutPrefix(baseURL, UnixScheme)
+	if !ok {
+		return "", "", false
+	}
+	socket, path, _ = strings.Cut(rest, ":")
+	return socket, strings.TrimSuffix(path, "/"), true
+}
+
+// unixDialer connects to the socket whatever the address of the request
+func unixDialer(socket string) DialFunc {
+	var dialer net.Dialer
+	return func(ctx context.Context, _, _ string) (net.Conn, error) {
+		return dialer.DialContext(ctx, "unix", socket)
+	}
+}
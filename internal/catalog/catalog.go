@@ -0,0 +1,176 @@
+// Package catalog checks event names used in permissions against the events
+// that exist on the server.
+package catalog
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"golang.org/x/sync/errgroup"
+
+	"github.com/rossi1/ensync-cli/internal/api"
+	"github.com/rossi1/ensync-cli/internal/domain"
+	"github.com/rossi1/ensync-cli/pkg/permission"
+)
+
+// maxSuggestions is the number of similar names offered for an unknown event
+const maxSuggestions = 3
+
+// maxLookups is the number of event lookups in flight at once
+const maxLookups = 8
+
+// Unknown is an event name that does not exist, with the closest existing
+// names as suggestions
+type Unknown struct {
+	Name        string   `json:"name"`
+	Suggestions []string `json:"suggestions,omitempty"`
+}
+
+func (u Unknown) String() string {
+	if len(u.Suggestions) == 0 {
+		return fmt.Sprintf("unknown event %q", u.Name)
+	}
+	return fmt.Sprintf("unknown event %q, did you mean %s?", u.Name, quoteJoin(u.Suggestions))
+}
+
+// Validator looks up event names on the server
+type Validator struct {
+	client *api.Client
+	// ListEvents returns every event, used to compute suggestions
+	ListEvents func(ctx context.Context) ([]*domain.Event, error)
+
+	events []string
+}
+
+func NewValidator(client *api.Client, listEvents func(ctx context.Context) ([]*domain.Event, error)) *Validator {
+	return &Validator{client: client, ListEvents: listEvents}
+}
+
+// CheckPermissions returns the send and receive entries that name events
+// which do not exist, in the order they appear. The events are looked up
+// concurrently. Prefix patterns are not checked.
+func (v *Validator) CheckPermissions(ctx context.Context, p *domain.Permissions) ([]Unknown, error) {
+	if p == nil {
+		return nil, nil
+	}
+
+	seen := map[string]bool{}
+	var names []string
+	for _, name := range append(append([]string{}, p.Send...), p.Receive...) {
+		if seen[name] || permission.IsPattern(name) {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+
+	missing := make([]bool, len(names))
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(maxLookups)
+	for i, name := range names {
+		g.Go(func() error {
+			_, err := v.client.GetEventByName(gctx, name)
+			if api.IsNotFound(err) {
+				missing[i] = true
+				return nil
+			}
+			return err
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	var unknown []Unknown
+	for i, name := range names {
+		if !missing[i] {
+			continue
+		}
+		suggestions, err := v.suggest(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		unknown = append(unknown, Unknown{Name: name, Suggestions: suggestions})
+	}
+
+	return unknown, nil
+}
+
+// suggest returns the existing event names closest to name by edit distance
+func (v *Validator) suggest(ctx context.Context, name string) ([]string, error) {
+	if v.events == nil {
+		events, err := v.ListEvents(ctx)
+		if err != nil {
+			return nil, err
+		}
+		v.events = make([]string, 0, len(events))
+		for _, event := range events {
+			v.events = append(v.events, event.Name)
+		}
+	}
+
+	// Only names within a quarter of the length are plausible typos
+	threshold := len(name) / 4
+	if threshold < 2 {
+		threshold = 2
+	}
+
+	type candidate struct {
+		name     string
+		distance int
+	}
+	var candidates []candidate
+	for _, existing := range v.events {
+		if d := Distance(name, existing); d <= threshold {
+			candidates = append(candidates, candidate{existing, d})
+		}
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].distance != candidates[j].distance {
+			return candidates[i].distance < candidates[j].distance
+		}
+		return candidates[i].name < candidates[j].name
+	})
+
+	var suggestions []string
+	for i := 0; i < len(candidates) && i < maxSuggestions; i++ {
+		suggestions = append(suggestions, candidates[i].name)
+	}
+	return suggestions, nil
+}
+
+// Distance returns the Levenshtein edit distance between a and b
+func Distance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+
+	return prev[len(rb)]
+}
+
+func quoteJoin(names []string) string {
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = fmt.Sprintf("%q", name)
+	}
+	return strings.Join(quoted, " or ")
+}
@@ -1,56 +1,541 @@
 package config
 
 import (
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 
+	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
+
+	"github.com/rossi1/ensync-cli/internal/credential"
+	"github.com/rossi1/ensync-cli/internal/domain"
+	"github.com/rossi1/ensync-cli/internal/platform/ratelimit"
 )
 
 type Config struct {
-	BaseURL string `mapstructure:"base_url"`
-	APIKey  string `mapstructure:"api_key"`
-	Debug   bool   `mapstructure:"debug"`
+	// Profile is the name of the active profile, empty when the top level
+	// settings of the config file are used
+	Profile string `mapstructure:"-"`
+
+	BaseURL          string                         `mapstructure:"base_url"`
+	APIKey           string                         `mapstructure:"api_key"`
+	Debug            bool                           `mapstructure:"debug"`
+	RateLimit        float64                        `mapstructure:"rate_limit"`
+	RateBurst        int                            `mapstructure:"rate_burst"`
+	Timeout          time.Duration                  `mapstructure:"timeout"`
+	Output           string                         `mapstructure:"output"`
+	ConfirmMutations bool                           `mapstructure:"confirm_mutations"`
+	Roles            map[string]*domain.Permissions `mapstructure:"roles"`
+	MetadataStore    string                         `mapstructure:"metadata_store"`
+	MetadataFile     string                         `mapstructure:"metadata_file"`
+
+	// CredentialHelper is a command printing the API key as JSON, used when
+	// no api_key is set
+	CredentialHelper string `mapstructure:"credential_helper"`
+	// CredentialsKeyFile holds the secret the credentials file is encrypted
+	// with, instead of a passphrase
+	CredentialsKeyFile string `mapstructure:"credentials_key_file"`
+
+	TLS            TLS            `mapstructure:"tls"`
+	Retry          Retry          `mapstructure:"retry"`
+	CircuitBreaker CircuitBreaker `mapstructure:"circuit_breaker"`
+
+	// RateLimits overrides rate_limit and rate_burst for reads or writes
+	RateLimits RateLimits `mapstructure:"rate_limits"`
+	// AdaptiveRateLimit follows the X-RateLimit headers of the server
+	AdaptiveRateLimit bool `mapstructure:"adaptive_rate_limit"`
+	// SharedRateLimit shares the rate limit budget of the profile with every
+	// other ensync process through files under the config dir
+	SharedRateLimit bool `mapstructure:"shared_rate_limit"`
+
+	// ProxyURL is the http, https or socks5 proxy of every request, and
+	// NoProxy the comma-separated hosts reached without it
+	ProxyURL string `mapstructure:"proxy_url"`
+	NoProxy  string `mapstructure:"no_proxy"`
+
+	// Connection pool settings, zero keeps the defaults of the HTTP client
+	MaxIdleConns        int           `mapstructure:"max_idle_conns"`
+	MaxIdleConnsPerHost int           `mapstructure:"max_idle_conns_per_host"`
+	MaxConnsPerHost     int           `mapstructure:"max_conns_per_host"`
+	IdleConnTimeout     time.Duration `mapstructure:"idle_conn_timeout"`
+
+	// Namespace is prepended to event names given on the command line
+	Namespace string `mapstructure:"namespace"`
+	// Manifest is the path of the event manifest of the project
+	Manifest string `mapstructure:"manifest"`
+
+	// ProjectFile is the project config file merged over the user config
+	// file, empty when there is none
+	ProjectFile string `mapstructure:"-"`
+	// Credentials refreshes the API key when it came from the credential
+	// helper, nil otherwise
+	Credentials *credential.Helper `mapstructure:"-"`
+
+	settings []Setting
+}
+
+// TLS holds the TLS settings of a profile
+type TLS struct {
+	CAFile             string   `mapstructure:"ca_file"`
+	CertFile           string   `mapstructure:"cert_file"`
+	KeyFile            string   `mapstructure:"key_file"`
+	ServerName         string   `mapstructure:"server_name"`
+	PinnedSPKI         []string `mapstructure:"pinned_spki"`
+	InsecureSkipVerify bool     `mapstructure:"insecure_skip_verify"`
+}
+
+// Retry holds the retry policy of a profile, zero values keep the defaults
+// of the client
+type Retry struct {
+	MaxAttempts   int           `mapstructure:"max_attempts"`
+	MinWait       time.Duration `mapstructure:"min_wait"`
+	MaxWait       time.Duration `mapstructure:"max_wait"`
+	MaxRetryAfter time.Duration `mapstructure:"max_retry_after"`
+	// NonIdempotent also retries requests that may not be safe to repeat
+	NonIdempotent bool `mapstructure:"non_idempotent"`
+}
+
+// CircuitBreaker holds the circuit breaker settings of a profile, zero
+// values keep the defaults of the client
+type CircuitBreaker struct {
+	Failures int           `mapstructure:"failures"`
+	Window   time.Duration `mapstructure:"window"`
+	CoolDown time.Duration `mapstructure:"cool_down"`
+}
+
+// RateLimits holds the rate limits of the read and write buckets
+type RateLimits struct {
+	Read  BucketLimits `mapstructure:"read"`
+	Write BucketLimits `mapstructure:"write"`
+}
+
+// BucketLimits holds the rate limit of a bucket, zero values fall back to
+// rate_limit and rate_burst. Max caps the rate the adaptive limiter speeds
+// up to.
+type BucketLimits struct {
+	Rate  float64 `mapstructure:"rate"`
+	Burst int     `mapstructure:"burst"`
+	Max   float64 `mapstructure:"max"`
+}
+
+// RateLimitConfig returns the configuration of the rate limiter
+func (c *Config) RateLimitConfig() ratelimit.Config {
+	limits := func(bucket BucketLimits) ratelimit.Limits {
+		result := ratelimit.Limits{Rate: c.RateLimit, Burst: c.RateBurst, Max: bucket.Max}
+		if bucket.Rate > 0 {
+			result.Rate = bucket.Rate
+		}
+		if bucket.Burst > 0 {
+			result.Burst = bucket.Burst
+		}
+		return result
+	}
+
+	config := ratelimit.Config{
+		Default: limits(BucketLimits{}),
+		Buckets: map[ratelimit.Bucket]ratelimit.Limits{
+			ratelimit.Read:  limits(c.RateLimits.Read),
+			ratelimit.Write: limits(c.RateLimits.Write),
+		},
+		Adaptive: c.AdaptiveRateLimit,
+	}
+	if c.SharedRateLimit {
+		config.SharedDir = RateLimitDir(c.Profile)
+	}
+	return config
+}
+
+// RateLimitDir returns the directory holding the shared rate limit budget of
+// a profile
+func RateLimitDir(profile string) string {
+	return filepath.Join(Dir(), "ratelimit", StoreProfile(profile))
+}
+
+// Profile holds the settings of a named profile. Any setting left empty
+// falls back to the top level of the config file.
+type Profile struct {
+	BaseURL          string        `mapstructure:"base_url" json:"base_url,omitempty"`
+	APIKey           string        `mapstructure:"api_key" json:"-"`
+	CredentialHelper string        `mapstructure:"credential_helper" json:"credential_helper,omitempty"`
+	RateLimit        float64       `mapstructure:"rate_limit" json:"rate_limit,omitempty"`
+	RateBurst        int           `mapstructure:"rate_burst" json:"rate_burst,omitempty"`
+	Timeout          time.Duration `mapstructure:"timeout" json:"timeout,omitempty"`
+	Output           string        `mapstructure:"output" json:"output,omitempty"`
+	ConfirmMutations bool          `mapstructure:"confirm_mutations" json:"confirm_mutations,omitempty"`
 }
 
-func Load() (*Config, error) {
+// Keys of the config file
+const (
+	keyCurrentContext = "current_context"
+	keyProfiles       = "profiles"
+)
+
+// DefaultBaseURL is used when no base URL is configured
+const DefaultBaseURL = "http://localhost:8080/api/v1/ensync"
+
+// ProfileEnv selects the profile when --profile is not given
+const ProfileEnv = "ENSYNC_PROFILE"
+
+// EnvPrefix is prepended to the upper-cased setting name to form the
+// environment variable overriding it, e.g. ENSYNC_BASE_URL
+const EnvPrefix = "ENSYNC"
+
+// APIKeyEnv holds the API key when it is not in the config file
+const APIKeyEnv = EnvPrefix + "_API_KEY"
+
+// PassphraseEnv holds the passphrase of the encrypted credentials file
+const PassphraseEnv = EnvPrefix + "_PASSPHRASE"
+
+// settingKeys lists the keys that can be set in the config file, a profile,
+// the environment and, for some of them, a flag of the same name
+var settingKeys = []string{
+	"base_url",
+	"api_key",
+	"credential_helper",
+	"credentials_key_file",
+	"tls.ca_file",
+	"tls.cert_file",
+	"tls.key_file",
+	"tls.server_name",
+	"tls.pinned_spki",
+	"tls.insecure_skip_verify",
+	"retry.max_attempts",
+	"retry.min_wait",
+	"retry.max_wait",
+	"retry.max_retry_after",
+	"retry.non_idempotent",
+	"circuit_breaker.failures",
+	"circuit_breaker.window",
+	"circuit_breaker.cool_down",
+	"proxy_url",
+	"no_proxy",
+	"max_idle_conns",
+	"max_idle_conns_per_host",
+	"max_conns_per_host",
+	"idle_conn_timeout",
+	"debug",
+	"rate_limit",
+	"rate_burst",
+	"rate_limits.read.rate",
+	"rate_limits.read.burst",
+	"rate_limits.read.max",
+	"rate_limits.write.rate",
+	"rate_limits.write.burst",
+	"rate_limits.write.max",
+	"adaptive_rate_limit",
+	"shared_rate_limit",
+	"timeout",
+	"output",
+	"confirm_mutations",
+	"roles",
+	"metadata_store",
+	"metadata_file",
+	"namespace",
+	"manifest",
+}
+
+// LoadOptions selects the config file and profile to load
+type LoadOptions struct {
+	// File overrides the default config file location
+	File string
+	// Profile overrides ENSYNC_PROFILE and the current context of the file
+	Profile string
+	// Flags override every other source for the settings they define, such
+	// as --debug for debug
+	Flags *pflag.FlagSet
+	// Dir is where the search for a project config file starts, the working
+	// directory when empty
+	Dir string
+}
+
+// Load reads the config with flags taking precedence over the environment,
+// then the project config file, then the active profile, then the top level
+// of the user config file, then the defaults
+func Load(opts LoadOptions) (*Config, error) {
 	config := &Config{}
 
-	viper.SetDefault("base_url", "http://localhost:8080/api/v1/ensync")
-	viper.SetDefault("debug", false)
+	v := viper.New()
+	v.SetDefault("base_url", DefaultBaseURL)
+	v.SetDefault("debug", false)
+	v.SetDefault("rate_limit", ratelimit.DefaultRate)
+	v.SetDefault("rate_burst", ratelimit.DefaultBurst)
+	v.SetDefault("adaptive_rate_limit", true)
+	v.SetDefault("timeout", 30*time.Second)
+	v.SetDefault("output", "json")
+	v.SetDefault("confirm_mutations", false)
+	v.SetDefault("metadata_store", "file")
 
-	// Environment variables
-	viper.AutomaticEnv()
+	// Environment variables. Binding every setting makes Unmarshal see
+	// variables for settings that have no default, such as api_key.
+	v.SetEnvPrefix(EnvPrefix)
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	for _, key := range settingKeys {
+		if err := v.BindEnv(key); err != nil {
+			return nil, fmt.Errorf("failed to bind %s: %w", envName(key), err)
+		}
+	}
 
-	// Config file
-	configDir := getConfigDir()
-	viper.AddConfigPath(configDir)
-	viper.SetConfigName("config")
-	viper.SetConfigType("yaml")
+	// Flags
+	if opts.Flags != nil {
+		for _, key := range settingKeys {
+			if flag := opts.Flags.Lookup(flagName(key)); flag != nil {
+				if err := v.BindPFlag(key, flag); err != nil {
+					return nil, fmt.Errorf("failed to bind --%s: %w", flag.Name, err)
+				}
+			}
+		}
+	}
 
-	if err := viper.ReadInConfig(); err != nil {
-		if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
+	// Config file
+	if opts.File != "" {
+		if _, err := os.Stat(opts.File); err != nil {
 			return nil, fmt.Errorf("failed to read config file: %w", err)
 		}
 	}
+	if err := readConfigFile(v, opts.File); err != nil {
+		return nil, err
+	}
 
-	if err := viper.Unmarshal(config); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal config: %w", err)
+	inFile := map[string]bool{}
+	for _, key := range settingKeys {
+		inFile[key] = v.InConfig(key)
 	}
 
-	// Validate required fields
-	if config.APIKey == "" {
-		config.APIKey = os.Getenv("ENSYNC_API_KEY")
+	project, err := loadProjectFile(opts.Dir)
+	if err != nil {
+		return nil, err
 	}
 
-	if config.APIKey == "" {
-		return nil, fmt.Errorf("API key is required")
+	profile := activeProfile(opts, v, project)
+
+	// Overlay the profile on the top level settings of the file
+	if profile != "" {
+		key := keyProfiles + "." + profile
+		if !v.IsSet(key) {
+			return nil, fmt.Errorf("profile %q not found in config file", profile)
+		}
+		if err := v.MergeConfigMap(v.GetStringMap(key)); err != nil {
+			return nil, fmt.Errorf("failed to apply profile %q: %w", profile, err)
+		}
 	}
 
+	// Overlay the project file on the user config file
+	if project != nil {
+		if err := v.MergeConfigMap(project.overrides()); err != nil {
+			return nil, fmt.Errorf("failed to apply project config file %s: %w", project.Path, err)
+		}
+		config.ProjectFile = project.Path
+	}
+
+	if err := v.Unmarshal(config); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal config: %w", err)
+	}
+	config.Profile = profile
+	config.settings = settings(v, opts, profile, inFile, project)
+
 	return config, nil
 }
 
+// ActiveProfile returns the profile Load would use, without loading the
+// config
+func ActiveProfile(opts LoadOptions) (string, error) {
+	v := viper.New()
+	if err := readConfigFile(v, opts.File); err != nil {
+		return "", err
+	}
+
+	project, err := loadProjectFile(opts.Dir)
+	if err != nil {
+		return "", err
+	}
+
+	return activeProfile(opts, v, project), nil
+}
+
+// activeProfile picks the profile from the options, then ENSYNC_PROFILE,
+// then the project file, then the current context of the config file
+func activeProfile(opts LoadOptions, v *viper.Viper, project *projectConfig) string {
+	if opts.Profile != "" {
+		return opts.Profile
+	}
+	if profile := os.Getenv(ProfileEnv); profile != "" {
+		return profile
+	}
+	if profile := project.profile(); profile != "" {
+		return profile
+	}
+	return v.GetString(keyCurrentContext)
+}
+
+// Source is the kind of place a setting was read from
+type Source string
+
+const (
+	SourceFlag    Source = "flag"
+	SourceEnv     Source = "env"
+	SourceProject Source = "project"
+	SourceHelper  Source = "credential_helper"
+	SourceStore   Source = "credentials"
+	SourceProfile Source = "profile"
+	SourceFile    Source = "file"
+	SourceDefault Source = "default"
+)
+
+// Origin tells where the value of a setting came from
+type Origin struct {
+	Source Source
+	// Name is the flag, environment variable, profile or file path
+	Name string
+}
+
+func (o Origin) String() string {
+	if o.Name == "" {
+		return string(o.Source)
+	}
+	return string(o.Source) + ":" + o.Name
+}
+
+// Setting is the effective value of a setting and where it came from
+type Setting struct {
+	Key    string
+	Value  interface{}
+	Origin Origin
+}
+
+// Settings returns every setting in a stable order, with secrets redacted
+func (c *Config) Settings() []Setting {
+	return c.settings
+}
+
+// setOrigin replaces the value and origin of a setting worked out by Load
+func (c *Config) setOrigin(key string, value interface{}, origin Origin) {
+	for i := range c.settings {
+		if c.settings[i].Key == key {
+			c.settings[i].Value = value
+			c.settings[i].Origin = origin
+		}
+	}
+}
+
+// settings works out the value and source of every setting, following the
+// precedence of Load
+func settings(v *viper.Viper, opts LoadOptions, profile string, inFile map[string]bool, project *projectConfig) []Setting {
+	result := make([]Setting, 0, len(settingKeys))
+	for _, key := range settingKeys {
+		setting := Setting{Key: key, Value: v.Get(key)}
+		if IsSecret(key) && setting.Value != nil && setting.Value != "" {
+			setting.Value = redacted
+		}
+
+		switch {
+		case opts.Flags != nil && opts.Flags.Changed(flagName(key)):
+			setting.Origin = Origin{Source: SourceFlag, Name: "--" + flagName(key)}
+		case os.Getenv(envName(key)) != "":
+			setting.Origin = Origin{Source: SourceEnv, Name: envName(key)}
+		case project.has(key):
+			setting.Origin = Origin{Source: SourceProject, Name: project.Path}
+		case profile != "" && v.InConfig(ProfileKey(profile, key)):
+			setting.Origin = Origin{Source: SourceProfile, Name: profile}
+		case inFile[key]:
+			setting.Origin = Origin{Source: SourceFile, Name: v.ConfigFileUsed()}
+		default:
+			setting.Origin = Origin{Source: SourceDefault}
+		}
+
+		result = append(result, setting)
+	}
+	return result
+}
+
+// envName returns the environment variable overriding a setting
+func envName(key string) string {
+	return EnvPrefix + "_" + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+// flagName returns the flag overriding a setting
+func flagName(key string) string {
+	return strings.ReplaceAll(key, "_", "-")
+}
+
+// Contexts returns the profiles defined in the config file and the current
+// context
+func Contexts(file string) (map[string]*Profile, string, error) {
+	v := viper.New()
+	if err := readConfigFile(v, file); err != nil {
+		return nil, "", err
+	}
+
+	profiles := map[string]*Profile{}
+	if err := v.UnmarshalKey(keyProfiles, &profiles); err != nil {
+		return nil, "", fmt.Errorf("failed to unmarshal profiles: %w", err)
+	}
+
+	return profiles, v.GetString(keyCurrentContext), nil
+}
+
+// ProfileNames returns the sorted names of the profiles
+func ProfileNames(profiles map[string]*Profile) []string {
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// UseContext makes the named profile the default for later invocations
+func UseContext(file, name string) error {
+	profiles, _, err := Contexts(file)
+	if err != nil {
+		return err
+	}
+	if _, ok := profiles[name]; !ok {
+		return fmt.Errorf("profile %q not found in config file", name)
+	}
+
+	return SetValue(file, keyCurrentContext, name)
+}
+
+// Path returns the config file to use, file itself when it is set
+func Path(file string) string {
+	if file != "" {
+		return file
+	}
+	return filepath.Join(getConfigDir(), "config.yaml")
+}
+
+// readConfigFile reads the config file into v. A missing file is not an
+// error, it is created on the first write.
+func readConfigFile(v *viper.Viper, file string) error {
+	if file != "" {
+		v.SetConfigFile(file)
+	} else {
+		v.AddConfigPath(getConfigDir())
+		v.SetConfigName("config")
+	}
+	v.SetConfigType("yaml")
+
+	if err := v.ReadInConfig(); err != nil {
+		var notFound viper.ConfigFileNotFoundError
+		if errors.As(err, &notFound) || errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+	return nil
+}
+
+// Dir returns the directory holding the config file and other local state
+func Dir() string {
+	return getConfigDir()
+}
+
 func getConfigDir() string {
 	if configDir := os.Getenv("ENSYNC_CONFIG_DIR"); configDir != "" {
 		return configDir
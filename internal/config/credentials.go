@@ -0,0 +1,162 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/spf13/viper"
+
+	"github.com/rossi1/ensync-cli/internal/credential"
+)
+
+// CredentialStore returns the encrypted store of API keys in the config dir
+func CredentialStore() *credential.Store {
+	return credential.NewStore(filepath.Join(getConfigDir(), credential.StoreFile))
+}
+
+// StoreProfile returns the name an API key is stored under for a profile
+func StoreProfile(profile string) string {
+	if profile == "" {
+		return credential.DefaultProfile
+	}
+	return profile
+}
+
+// helperCachePath returns the file caching the credential helper output of a
+// profile
+func helperCachePath(profile string) string {
+	return filepath.Join(getConfigDir(), credential.HelperCacheDir, url.PathEscape(StoreProfile(profile))+".json")
+}
+
+// Secret returns the secret protecting the credentials file: the key file
+// when one is given, then $ENSYNC_PASSPHRASE, then whatever ask returns
+func Secret(keyFile string, ask func(prompt string) ([]byte, error), prompt string) ([]byte, error) {
+	if keyFile != "" {
+		return credential.ReadKeyFile(keyFile)
+	}
+	if passphrase := os.Getenv(PassphraseEnv); passphrase != "" {
+		return []byte(passphrase), nil
+	}
+	if ask == nil {
+		return nil, fmt.Errorf("a passphrase is required: set $%s or credentials_key_file", PassphraseEnv)
+	}
+	return ask(prompt)
+}
+
+// ResolveAPIKey sets the API key from the credentials file or the credential
+// helper when no api_key is set. Load leaves it to the commands calling the
+// API, since decrypting the key may ask for a passphrase and the helper runs
+// a command. passphrase asks for the passphrase of the credentials file when
+// neither $ENSYNC_PASSPHRASE nor credentials_key_file is set.
+func (c *Config) ResolveAPIKey(passphrase func(prompt string) ([]byte, error)) error {
+	if c.APIKey != "" {
+		return nil
+	}
+
+	if err := c.openStoredKey(passphrase); err != nil {
+		return err
+	}
+	if c.APIKey != "" || c.CredentialHelper == "" {
+		return nil
+	}
+
+	helper := credential.NewHelper(c.CredentialHelper, credential.WithCacheFile(helperCachePath(c.Profile)))
+	apiKey, err := helper.APIKey(context.Background())
+	if err != nil {
+		return err
+	}
+	c.APIKey = apiKey
+	c.Credentials = helper
+	c.setOrigin("api_key", redacted, Origin{Source: SourceHelper, Name: c.CredentialHelper})
+	return nil
+}
+
+// openStoredKey decrypts the API key of the active profile from the
+// credentials file, if one is stored
+func (c *Config) openStoredKey(passphrase func(prompt string) ([]byte, error)) error {
+	store := CredentialStore()
+
+	// Like settings in the config file, a profile without a key of its own
+	// falls back to the top level one
+	name := ""
+	for _, candidate := range []string{StoreProfile(c.Profile), credential.DefaultProfile} {
+		ok, err := store.Has(candidate)
+		if err != nil {
+			return err
+		}
+		if ok {
+			name = candidate
+			break
+		}
+	}
+	if name == "" {
+		return nil
+	}
+
+	secret, err := Secret(c.CredentialsKeyFile, passphrase, fmt.Sprintf("Passphrase for the API key of profile %q: ", name))
+	if err != nil {
+		return err
+	}
+
+	apiKey, err := store.Open(name, secret)
+	if err != nil {
+		return err
+	}
+
+	c.APIKey = apiKey
+	c.setOrigin("api_key", redacted, Origin{Source: SourceStore, Name: store.Path()})
+	return nil
+}
+
+// PlainTextAPIKey reports whether the API key was read unencrypted from the
+// config file
+func (c *Config) PlainTextAPIKey() bool {
+	for _, setting := range c.settings {
+		if setting.Key == "api_key" {
+			return setting.Origin.Source == SourceFile || setting.Origin.Source == SourceProfile
+		}
+	}
+	return false
+}
+
+// PlainTextKey is an API key stored unencrypted in the config file
+type PlainTextKey struct {
+	// Key is the setting holding it, such as profiles.prod.api_key
+	Key string
+	// Profile is the name to store it under in the credentials file
+	Profile string
+	APIKey  string
+}
+
+// PlainTextAPIKeys returns the API keys stored unencrypted in the config
+// file, at the top level and in profiles
+func PlainTextAPIKeys(file string) ([]PlainTextKey, error) {
+	v := viper.New()
+	if err := readConfigFile(v, file); err != nil {
+		return nil, err
+	}
+
+	var keys []PlainTextKey
+	if apiKey := v.GetString("api_key"); apiKey != "" {
+		keys = append(keys, PlainTextKey{Key: "api_key", Profile: credential.DefaultProfile, APIKey: apiKey})
+	}
+
+	profiles := v.GetStringMap(keyProfiles)
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		key := ProfileKey(name, "api_key")
+		if apiKey := v.GetString(key); apiKey != "" {
+			keys = append(keys, PlainTextKey{Key: key, Profile: name, APIKey: apiKey})
+		}
+	}
+	return keys, nil
+}
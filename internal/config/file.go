@@ -0,0 +1,292 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+// FileMode is the permission the config file is written with, since it may
+// hold credentials
+const FileMode os.FileMode = 0o600
+
+// redacted replaces secrets in config views
+const redacted = "********"
+
+// secretKeys are the settings never shown in config views
+var secretKeys = map[string]bool{
+	"api_key": true,
+}
+
+// IsSecret reports whether the setting, possibly nested under a profile,
+// holds a secret
+func IsSecret(key string) bool {
+	parts := strings.Split(key, ".")
+	return secretKeys[strings.ToLower(parts[len(parts)-1])]
+}
+
+// ProfileKey returns the key of a setting inside the named profile, or the
+// top level key when profile is empty
+func ProfileKey(profile, key string) string {
+	if profile == "" {
+		return key
+	}
+	return keyProfiles + "." + profile + "." + key
+}
+
+// GetValue returns a setting as stored in the config file
+func GetValue(file, key string) (interface{}, bool, error) {
+	v := viper.New()
+	if err := readConfigFile(v, file); err != nil {
+		return nil, false, err
+	}
+
+	if !v.IsSet(key) {
+		return nil, false, nil
+	}
+	return v.Get(key), true, nil
+}
+
+// SetValue stores a setting in the config file. Booleans, numbers, durations
+// and lists are parsed according to the type of the setting; every other
+// value, such as a URL or an ID, is stored as the string given.
+func SetValue(file, key string, value string) error {
+	parsed, err := parseValue(key, value)
+	if err != nil {
+		return err
+	}
+
+	v := viper.New()
+	if err := readConfigFile(v, file); err != nil {
+		return err
+	}
+	v.Set(key, parsed)
+
+	return writeConfigFile(v, Path(file))
+}
+
+// parseValue converts a value given on the command line to the type of the
+// setting
+func parseValue(key, value string) (interface{}, error) {
+	t := settingType(key)
+	if t == nil {
+		return value, nil
+	}
+
+	if t == reflect.TypeOf(time.Duration(0)) {
+		if _, err := time.ParseDuration(value); err != nil {
+			return nil, fmt.Errorf("invalid value %q for %s: want a duration such as 30s", value, key)
+		}
+		return value, nil
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value %q for %s: want true or false", value, key)
+		}
+		return b, nil
+	case reflect.Int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value %q for %s: want an integer", value, key)
+		}
+		return n, nil
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value %q for %s: want a number", value, key)
+		}
+		return f, nil
+	case reflect.Slice:
+		// A list is given in YAML flow style, [a, b], or as a single entry
+		var list []string
+		if err := yaml.Unmarshal([]byte(value), &list); err != nil {
+			list = []string{value}
+		}
+		return list, nil
+	default:
+		return value, nil
+	}
+}
+
+// settingType returns the type of the Config field a key, possibly nested
+// under a profile, is decoded into, or nil when the key is unknown
+func settingType(key string) reflect.Type {
+	parts := strings.Split(strings.ToLower(key), ".")
+	if parts[0] == keyProfiles && len(parts) > 2 {
+		parts = parts[2:]
+	}
+
+	t := reflect.TypeOf(Config{})
+	for len(parts) > 0 {
+		for t.Kind() == reflect.Pointer {
+			t = t.Elem()
+		}
+
+		switch t.Kind() {
+		case reflect.Struct:
+			field, ok := fieldByTag(t, parts[0])
+			if !ok {
+				return nil
+			}
+			t = field.Type
+		case reflect.Map:
+			t = t.Elem()
+		default:
+			return nil
+		}
+		parts = parts[1:]
+	}
+
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return t
+}
+
+// fieldByTag finds a struct field by the name it has in the config file
+func fieldByTag(t reflect.Type, name string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("mapstructure")
+		if tag == "" {
+			tag, _, _ = strings.Cut(field.Tag.Get("json"), ",")
+		}
+		if tag == "" {
+			tag = strings.ToLower(field.Name)
+		}
+		if tag == name {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// SetValues stores several settings in the config file at once
+func SetValues(file string, values map[string]interface{}) error {
+	v := viper.New()
+	if err := readConfigFile(v, file); err != nil {
+		return err
+	}
+	for key, value := range values {
+		v.Set(key, value)
+	}
+
+	return writeConfigFile(v, Path(file))
+}
+
+// UnsetValues removes settings from the config file. Unlike the viper based
+// writers it keeps profiles left empty, so they can still be selected.
+func UnsetValues(file string, keys []string) error {
+	path := Path(file)
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	settings := map[string]interface{}{}
+	if err := yaml.Unmarshal(data, &settings); err != nil {
+		return fmt.Errorf("failed to parse config file: %w", err)
+	}
+	for _, key := range keys {
+		unset(settings, strings.Split(key, "."))
+	}
+
+	data, err = yaml.Marshal(settings)
+	if err != nil {
+		return fmt.Errorf("failed to encode config file: %w", err)
+	}
+	if err := os.WriteFile(path, data, FileMode); err != nil {
+		return fmt.Errorf("failed to write config file: %w", err)
+	}
+	if err := os.Chmod(path, FileMode); err != nil {
+		return fmt.Errorf("failed to set config file permissions: %w", err)
+	}
+	return nil
+}
+
+func unset(settings map[string]interface{}, path []string) {
+	if len(path) == 1 {
+		delete(settings, path[0])
+		return
+	}
+	if nested, ok := settings[path[0]].(map[string]interface{}); ok {
+		unset(nested, path[1:])
+	}
+}
+
+// View returns the settings of the config file with secrets redacted
+func View(file string) (map[string]interface{}, error) {
+	v := viper.New()
+	if err := readConfigFile(v, file); err != nil {
+		return nil, err
+	}
+
+	settings := v.AllSettings()
+	redact(settings)
+	return settings, nil
+}
+
+func redact(settings map[string]interface{}) {
+	for key, value := range settings {
+		switch value := value.(type) {
+		case map[string]interface{}:
+			redact(value)
+		default:
+			if IsSecret(key) && value != "" {
+				settings[key] = redacted
+			}
+		}
+	}
+}
+
+// CheckPermissions returns a warning when the config file can be read or
+// written by other users. A missing file gets no warning.
+func CheckPermissions(file string) string {
+	path := Path(file)
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return ""
+	}
+
+	if mode := info.Mode().Perm(); mode&^FileMode != 0 {
+		return fmt.Sprintf("config file %s has permissions %#o, run: chmod %#o %s", path, mode, FileMode, path)
+	}
+	return ""
+}
+
+// writeConfigFile writes only the settings read from the file or set
+// explicitly, never defaults or environment variables, and restricts the
+// file to its owner
+func writeConfigFile(v *viper.Viper, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("failed to create config directory: %w", err)
+	}
+
+	v.SetConfigPermissions(FileMode)
+	if err := v.WriteConfigAs(path); err != nil {
+		return fmt.Errorf("failed to write config file: %w", err)
+	}
+
+	// WriteConfigAs keeps the mode of an existing file
+	if err := os.Chmod(path, FileMode); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to set config file permissions: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ProjectFile is the name of the project config file. It is looked up from
+// the working directory upwards and merged over the user config file.
+const ProjectFile = ".ensync.yaml"
+
+// keyProfile selects the profile from a project file
+const keyProfile = "profile"
+
+// projectKeys are the only settings a project file may set. Project files
+// come with the code that is checked out, so they must not be able to run
+// commands, redirect requests or weaken TLS.
+var projectKeys = map[string]bool{
+	keyProfile:  true,
+	"namespace": true,
+	"manifest":  true,
+	"output":    true,
+}
+
+// projectConfig is a project config file that was found and read
+type projectConfig struct {
+	Path     string
+	Settings map[string]interface{}
+}
+
+// FindProjectFile returns the project config file closest to dir, searching
+// its parents the way git finds .git, or an empty string when there is none
+func FindProjectFile(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s: %w", dir, err)
+	}
+
+	for {
+		path := filepath.Join(dir, ProjectFile)
+		info, err := os.Stat(path)
+		if err == nil && !info.IsDir() {
+			return path, nil
+		}
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return "", fmt.Errorf("failed to check %s: %w", path, err)
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", nil
+		}
+		dir = parent
+	}
+}
+
+// loadProjectFile finds and reads the project config file above dir. It
+// returns nil when there is none. Files holding API keys or any setting other
+// than projectKeys are refused, since project files are meant to be committed
+// with the code.
+func loadProjectFile(dir string) (*projectConfig, error) {
+	if dir == "" {
+		wd, err := os.Getwd()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get working directory: %w", err)
+		}
+		dir = wd
+	}
+
+	path, err := FindProjectFile(dir)
+	if err != nil || path == "" {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read project config file: %w", err)
+	}
+
+	settings := map[string]interface{}{}
+	if err := yaml.Unmarshal(data, &settings); err != nil {
+		return nil, fmt.Errorf("failed to parse project config file %s: %w", path, err)
+	}
+
+	if key, ok := findSecret(settings, ""); ok {
+		return nil, fmt.Errorf("refusing to use project config file %s: it sets %s. "+
+			"Project files are shared with everyone who checks out the code, keep API keys in %s or $%s instead",
+			path, key, Path(""), APIKeyEnv)
+	}
+	if key, ok := findDisallowed(settings); ok {
+		return nil, fmt.Errorf("refusing to use project config file %s: it sets %s. "+
+			"Project files may only set %s, keep other settings in %s",
+			path, key, strings.Join(allowedProjectKeys(), ", "), Path(""))
+	}
+
+	// The manifest path is relative to the project file, not to the
+	// directory the command runs in
+	if manifest, ok := settings["manifest"].(string); ok && manifest != "" && !filepath.IsAbs(manifest) {
+		settings["manifest"] = filepath.Join(filepath.Dir(path), manifest)
+	}
+
+	return &projectConfig{Path: path, Settings: settings}, nil
+}
+
+// findSecret returns the first key holding a secret, at any depth
+func findSecret(settings map[string]interface{}, prefix string) (string, bool) {
+	for key, value := range settings {
+		full := key
+		if prefix != "" {
+			full = prefix + "." + key
+		}
+
+		if IsSecret(full) {
+			return full, true
+		}
+		if nested, ok := value.(map[string]interface{}); ok {
+			if found, ok := findSecret(nested, full); ok {
+				return found, true
+			}
+		}
+	}
+	return "", false
+}
+
+// findDisallowed returns the first setting, in sorted order, that project
+// files may not set
+func findDisallowed(settings map[string]interface{}) (string, bool) {
+	keys := make([]string, 0, len(settings))
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if !projectKeys[strings.ToLower(key)] {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+func allowedProjectKeys() []string {
+	keys := make([]string, 0, len(projectKeys))
+	for key := range projectKeys {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// has reports whether the project file sets the key, which may be nested
+// such as tls.ca_file
+func (p *projectConfig) has(key string) bool {
+	if p == nil {
+		return false
+	}
+
+	settings := p.Settings
+	parts := strings.Split(strings.ToLower(key), ".")
+	for _, part := range parts[:len(parts)-1] {
+		nested, ok := settings[part].(map[string]interface{})
+		if !ok {
+			return false
+		}
+		settings = nested
+	}
+	_, ok := settings[parts[len(parts)-1]]
+	return ok
+}
+
+// profile returns the profile the project file selects
+func (p *projectConfig) profile() string {
+	if p == nil {
+		return ""
+	}
+	name, _ := p.Settings[keyProfile].(string)
+	return name
+}
+
+// overrides returns the settings to merge over the user config file
+func (p *projectConfig) overrides() map[string]interface{} {
+	result := make(map[string]interface{}, len(p.Settings))
+	for key, value := range p.Settings {
+		if key != keyProfile {
+			result[key] = value
+		}
+	}
+	return result
+}
@@ -0,0 +1,187 @@
+// Package credential obtains API keys from external credential helpers, in
+// the spirit of git and docker credential helpers.
+package credential
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// expirySkew makes a credential count as expired slightly before its expiry
+// date, so that it does not expire while a request is in flight
+const expirySkew = 30 * time.Second
+
+// Credential is what a helper prints on stdout
+type Credential struct {
+	APIKey string `json:"api_key"`
+	// ExpiresAt is when the key stops working, nil when it does not expire
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// Expired reports whether the credential must be fetched again
+func (c *Credential) Expired(now time.Time) bool {
+	return c.ExpiresAt != nil && !now.Add(expirySkew).Before(*c.ExpiresAt)
+}
+
+// HelperCacheDir is the directory, inside the config dir, holding the
+// credentials returned by helpers, one file per profile
+const HelperCacheDir = "credential-helper"
+
+// Helper runs a command to get the API key. The result is cached in memory,
+// and in a file when one is given, until it expires or the server rejects it.
+type Helper struct {
+	command   string
+	cacheFile string
+	now       func() time.Time
+
+	mu     sync.Mutex
+	cached *Credential
+}
+
+// HelperOption configures a Helper
+type HelperOption func(*Helper)
+
+// WithCacheFile keeps the credential in path between runs of the CLI, so the
+// helper is not run by every command
+func WithCacheFile(path string) HelperOption {
+	return func(h *Helper) {
+		h.cacheFile = path
+	}
+}
+
+func NewHelper(command string, opts ...HelperOption) *Helper {
+	h := &Helper{command: command, now: time.Now}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+// APIKey returns the cached key, running the helper when there is none or it
+// has expired
+func (h *Helper) APIKey(ctx context.Context) (string, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.cached == nil {
+		h.cached = h.readCache()
+	}
+	if h.cached != nil && !h.cached.Expired(h.now()) {
+		return h.cached.APIKey, nil
+	}
+	return h.run(ctx)
+}
+
+// Refresh runs the helper again, for example after the server answered 401
+func (h *Helper) Refresh(ctx context.Context) (string, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.cached = nil
+	if h.cacheFile != "" {
+		_ = os.Remove(h.cacheFile)
+	}
+	return h.run(ctx)
+}
+
+func (h *Helper) run(ctx context.Context) (string, error) {
+	cmd := exec.CommandContext(ctx, "sh", "-c", h.command)
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	// Stdin is left alone since commands such as "create --file -" read it,
+	// helpers that prompt have to use the terminal directly
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return "", fmt.Errorf("credential helper %q exited with status %d", h.command, exitErr.ExitCode())
+		}
+		return "", fmt.Errorf("failed to run credential helper %q: %w", h.command, err)
+	}
+
+	var cred Credential
+	if err := json.Unmarshal(stdout.Bytes(), &cred); err != nil {
+		return "", fmt.Errorf("failed to parse output of credential helper %q: %w", h.command, err)
+	}
+
+	cred.APIKey = strings.TrimSpace(cred.APIKey)
+	if cred.APIKey == "" {
+		return "", fmt.Errorf("credential helper %q returned no api_key", h.command)
+	}
+	if cred.Expired(h.now()) {
+		return "", fmt.Errorf("credential helper %q returned an expired api_key", h.command)
+	}
+
+	h.cached = &cred
+	// The cache only saves runs of the helper, failing to write it is not
+	// worth failing the command
+	_ = h.writeCache(&cred)
+	return cred.APIKey, nil
+}
+
+// cacheEntry is the content of the cache file. The command is recorded so
+// that changing credential_helper does not keep using the old key.
+type cacheEntry struct {
+	Command string `json:"command"`
+	Credential
+}
+
+// readCache returns the credential of the cache file, nil when there is none
+// or it was written for another command
+func (h *Helper) readCache() *Credential {
+	if h.cacheFile == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(h.cacheFile)
+	if err != nil {
+		return nil
+	}
+	var entry cacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil || entry.Command != h.command || entry.APIKey == "" {
+		return nil
+	}
+	return &entry.Credential
+}
+
+// writeCache replaces the cache file atomically, readable by its owner only
+func (h *Helper) writeCache(cred *Credential) error {
+	if h.cacheFile == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(&cacheEntry{Command: h.command, Credential: *cred})
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(h.cacheFile)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(h.cacheFile)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), h.cacheFile)
+}
@@ -0,0 +1,262 @@
+package credential
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// StoreFile is the name of the encrypted credentials file inside the config dir
+const StoreFile = "credentials.json"
+
+// DefaultProfile names the key used when no profile is active
+const DefaultProfile = "default"
+
+const (
+	storeVersion = 1
+	// iterations follows the OWASP recommendation for PBKDF2-HMAC-SHA256
+	iterations = 600000
+	// The iteration count is read from the file, so it is bounded: a low
+	// count makes the passphrase cheap to guess, a huge one hangs the CLI
+	minIterations = 100000
+	maxIterations = 10000000
+	saltSize      = 16
+	keySize       = 32
+)
+
+// sealedKey is an API key encrypted with AES-256-GCM, under a key derived
+// from the passphrase with PBKDF2-HMAC-SHA256
+type sealedKey struct {
+	Iterations int    `json:"iterations"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+type storeFile struct {
+	Version int                   `json:"version"`
+	Keys    map[string]*sealedKey `json:"keys"`
+}
+
+// Store keeps API keys encrypted at rest, one per profile. Each key has its
+// own salt, so profiles may use different passphrases.
+type Store struct {
+	path string
+}
+
+func NewStore(path string) *Store {
+	return &Store{path: path}
+}
+
+// Path returns the file the store is kept in
+func (s *Store) Path() string {
+	return s.path
+}
+
+// Has reports whether an API key is stored for the profile
+func (s *Store) Has(profile string) (bool, error) {
+	f, err := s.read()
+	if err != nil {
+		return false, err
+	}
+	_, ok := f.Keys[profile]
+	return ok, nil
+}
+
+// Profiles returns the sorted names of the profiles with a stored key
+func (s *Store) Profiles() ([]string, error) {
+	f, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(f.Keys))
+	for name := range f.Keys {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Save encrypts the API key with the secret and stores it for the profile,
+// replacing any key stored before
+func (s *Store) Save(profile, apiKey string, secret []byte) error {
+	if len(secret) == 0 {
+		return fmt.Errorf("passphrase must not be empty")
+	}
+
+	f, err := s.read()
+	if err != nil {
+		return err
+	}
+
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	aead, err := newAEAD(secret, salt, iterations)
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	f.Keys[profile] = &sealedKey{
+		Iterations: iterations,
+		Salt:       salt,
+		Nonce:      nonce,
+		// The profile is authenticated so that a key cannot be moved to
+		// another profile in the file
+		Ciphertext: aead.Seal(nil, nonce, []byte(apiKey), []byte(profile)),
+	}
+
+	return s.write(f)
+}
+
+// Open decrypts the API key stored for the profile
+func (s *Store) Open(profile string, secret []byte) (string, error) {
+	f, err := s.read()
+	if err != nil {
+		return "", err
+	}
+
+	sealed, ok := f.Keys[profile]
+	if !ok {
+		return "", fmt.Errorf("no API key stored for profile %q", profile)
+	}
+
+	aead, err := newAEAD(secret, sealed.Salt, sealed.Iterations)
+	if err != nil {
+		return "", err
+	}
+
+	apiKey, err := aead.Open(nil, sealed.Nonce, sealed.Ciphertext, []byte(profile))
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt the API key of profile %q: wrong passphrase or key file", profile)
+	}
+	return string(apiKey), nil
+}
+
+// Delete removes the key stored for the profile and reports whether there was
+// one. The file is removed with the last key.
+func (s *Store) Delete(profile string) (bool, error) {
+	f, err := s.read()
+	if err != nil {
+		return false, err
+	}
+
+	if _, ok := f.Keys[profile]; !ok {
+		return false, nil
+	}
+	delete(f.Keys, profile)
+
+	if len(f.Keys) == 0 {
+		if err := os.Remove(s.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return false, fmt.Errorf("failed to remove credentials file: %w", err)
+		}
+		return true, nil
+	}
+	return true, s.write(f)
+}
+
+func (s *Store) read() (*storeFile, error) {
+	f := &storeFile{Version: storeVersion, Keys: map[string]*sealedKey{}}
+
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return f, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read credentials file: %w", err)
+	}
+
+	if err := json.Unmarshal(data, f); err != nil {
+		return nil, fmt.Errorf("failed to parse credentials file %s: %w", s.path, err)
+	}
+	if f.Version != storeVersion {
+		return nil, fmt.Errorf("unsupported credentials file version %d in %s", f.Version, s.path)
+	}
+	if f.Keys == nil {
+		f.Keys = map[string]*sealedKey{}
+	}
+	return f, nil
+}
+
+// write replaces the file atomically, readable by its owner only
+func (s *Store) write(f *storeFile) error {
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode credentials file: %w", err)
+	}
+
+	dir := filepath.Dir(s.path)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("failed to create credentials directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(dir, ".credentials-*")
+	if err != nil {
+		return fmt.Errorf("failed to write credentials file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write credentials file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write credentials file: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to write credentials file: %w", err)
+	}
+	return nil
+}
+
+// ReadKeyFile returns the secret held in a key file. A trailing newline is
+// ignored so that files written by echo or editors work.
+func ReadKeyFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+
+	secret := []byte(strings.TrimRight(string(data), "\r\n"))
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("key file %s is empty", path)
+	}
+	return secret, nil
+}
+
+func newAEAD(secret, salt []byte, iter int) (cipher.AEAD, error) {
+	if iter < minIterations || iter > maxIterations {
+		return nil, fmt.Errorf("invalid iteration count %d in credentials file, want %d to %d", iter, minIterations, maxIterations)
+	}
+
+	block, err := aes.NewCipher(pbkdf2.Key(secret, salt, iter, keySize, sha256.New))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	return aead, nil
+}
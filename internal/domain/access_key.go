@@ -1,12 +1,15 @@
 package domain
 
+import "time"
+
 type AccessKey struct {
 	AccessKey string `json:"accessKey"`
 }
 
 type AccessKeyPermissions struct {
-	Key         string       `json:"key"`
-	Permissions *Permissions `json:"permissions"`
+	Key         string             `json:"key"`
+	Permissions *Permissions       `json:"permissions"`
+	Metadata    *AccessKeyMetadata `json:"metadata,omitempty"`
 }
 
 type Permissions struct {
@@ -18,3 +21,41 @@ type AccessKeyList struct {
 	ResultsLength int                     `json:"resultsLength"`
 	Results       []*AccessKeyPermissions `json:"results"`
 }
+
+// Merge returns the union of p and other, keeping the order in which entries
+// first appear and dropping duplicates
+func (p *Permissions) Merge(other *Permissions) *Permissions {
+	merged := &Permissions{Send: []string{}, Receive: []string{}}
+	for _, src := range []*Permissions{p, other} {
+		if src == nil {
+			continue
+		}
+		merged.Send = appendUnique(merged.Send, src.Send...)
+		merged.Receive = appendUnique(merged.Receive, src.Receive...)
+	}
+	return merged
+}
+
+func appendUnique(list []string, entries ...string) []string {
+	for _, entry := range entries {
+		found := false
+		for _, existing := range list {
+			if existing == entry {
+				found = true
+				break
+			}
+		}
+		if !found {
+			list = append(list, entry)
+		}
+	}
+	return list
+}
+
+// AccessKeyMetadata describes who owns an access key and what it is for
+type AccessKeyMetadata struct {
+	Labels      map[string]string `json:"labels,omitempty" yaml:"labels,omitempty"`
+	Owner       string            `json:"owner,omitempty" yaml:"owner,omitempty"`
+	Description string            `json:"description,omitempty" yaml:"description,omitempty"`
+	ExpiresAt   *time.Time        `json:"expiresAt,omitempty" yaml:"expiresAt,omitempty"`
+}
@@ -0,0 +1,125 @@
+// Package label parses and matches access key labels. It has no dependency
+// on where labels are stored, so policies and reports can select keys
+// without pulling in the API client.
+package label
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Selector matches access keys by label, using the same syntax as Kubernetes
+// equality based selectors: "team=payments,env!=prod,external".
+type Selector []requirement
+
+type requirement struct {
+	key    string
+	value  string
+	negate bool
+	exists bool
+}
+
+// ParseSelector parses a comma separated list of key=value, key!=value and
+// bare key (label must exist) requirements
+func ParseSelector(s string) (Selector, error) {
+	var sel Selector
+
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		var req requirement
+		switch {
+		case strings.Contains(part, "!="):
+			kv := strings.SplitN(part, "!=", 2)
+			req = requirement{key: strings.TrimSpace(kv[0]), value: strings.TrimSpace(kv[1]), negate: true}
+		case strings.Contains(part, "="):
+			kv := strings.SplitN(part, "=", 2)
+			req = requirement{key: strings.TrimSpace(kv[0]), value: strings.TrimSpace(kv[1])}
+		default:
+			req = requirement{key: part, exists: true}
+		}
+
+		if req.key == "" {
+			return nil, fmt.Errorf("invalid selector %q: missing label name", part)
+		}
+		sel = append(sel, req)
+	}
+
+	return sel, nil
+}
+
+// Matches reports whether the labels satisfy every requirement
+func (s Selector) Matches(labels map[string]string) bool {
+	for _, req := range s {
+		value, ok := labels[req.key]
+		switch {
+		case req.exists:
+			if !ok {
+				return false
+			}
+		case req.negate:
+			if ok && value == req.value {
+				return false
+			}
+		default:
+			if !ok || value != req.value {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Parse parses labels written as "key=value,key", the format Format
+// renders. A bare key is a label with an empty value.
+func Parse(s string) (map[string]string, error) {
+	labels := map[string]string{}
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		key, value, _ := strings.Cut(part, "=")
+		key = strings.TrimSpace(key)
+		if key == "" {
+			return nil, fmt.Errorf("invalid label %q: missing label name", part)
+		}
+		labels[key] = strings.TrimSpace(value)
+	}
+	return labels, nil
+}
+
+// Format renders labels as a sorted "key=value,key=value" string
+func Format(labels map[string]string) string {
+	parts := make([]string, 0, len(labels))
+	for k, v := range labels {
+		if v == "" {
+			parts = append(parts, k)
+			continue
+		}
+		parts = append(parts, k+"="+v)
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
+
+// Merge returns the labels of base overridden by those of overrides
+func Merge(base, overrides map[string]string) map[string]string {
+	if len(overrides) == 0 {
+		return base
+	}
+
+	merged := make(map[string]string, len(base)+len(overrides))
+	for k, v := range base {
+		merged[k] = v
+	}
+	for k, v := range overrides {
+		merged[k] = v
+	}
+	return merged
+}
@@ -0,0 +1,45 @@
+package metadata
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rossi1/ensync-cli/internal/domain"
+)
+
+// Labels returns the labels of a key's metadata, which may be nil
+func Labels(md *domain.AccessKeyMetadata) map[string]string {
+	if md == nil {
+		return nil
+	}
+	return md.Labels
+}
+
+// ExpiresWithin reports whether the key expires before now+d. Keys that
+// already expired are included, keys without an expiry date are not.
+func ExpiresWithin(md *domain.AccessKeyMetadata, now time.Time, d time.Duration) bool {
+	if md == nil || md.ExpiresAt == nil {
+		return false
+	}
+	return md.ExpiresAt.Before(now.Add(d))
+}
+
+// ParseDuration is time.ParseDuration with support for a "d" (days) suffix,
+// e.g. "30d"
+func ParseDuration(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	return d, nil
+}
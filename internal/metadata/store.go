@@ -0,0 +1,171 @@
+// Package metadata stores labels, owners, descriptions and expiry dates for
+// access keys, either on the EnSync server or in a local sidecar file.
+package metadata
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/rossi1/ensync-cli/internal/api"
+	"github.com/rossi1/ensync-cli/internal/domain"
+)
+
+// Store backends selectable in the config
+const (
+	BackendFile   = "file"
+	BackendServer = "server"
+)
+
+// DefaultFile is the name of the sidecar file inside the config dir
+const DefaultFile = "access-keys.yaml"
+
+// Store reads and writes access key metadata
+type Store interface {
+	// Load returns the metadata of every access key that has any
+	Load(ctx context.Context) (map[string]*domain.AccessKeyMetadata, error)
+	// Save replaces the metadata of an access key
+	Save(ctx context.Context, key string, metadata *domain.AccessKeyMetadata) error
+	// Delete removes the metadata of an access key
+	Delete(ctx context.Context, key string) error
+}
+
+// FileStore keeps metadata in a YAML file keyed by access key. The file can
+// live in a git repository to share it with a team.
+type FileStore struct {
+	path string
+	mu   sync.Mutex
+}
+
+func NewFileStore(path string) *FileStore {
+	return &FileStore{path: path}
+}
+
+func (s *FileStore) Load(ctx context.Context) (map[string]*domain.AccessKeyMetadata, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.read()
+}
+
+func (s *FileStore) Save(ctx context.Context, key string, metadata *domain.AccessKeyMetadata) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	all, err := s.read()
+	if err != nil {
+		return err
+	}
+	all[key] = metadata
+
+	return s.write(all)
+}
+
+func (s *FileStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	all, err := s.read()
+	if err != nil {
+		return err
+	}
+	if _, ok := all[key]; !ok {
+		return nil
+	}
+	delete(all, key)
+
+	return s.write(all)
+}
+
+func (s *FileStore) read() (map[string]*domain.AccessKeyMetadata, error) {
+	all := map[string]*domain.AccessKeyMetadata{}
+
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return all, nil
+		}
+		return nil, fmt.Errorf("failed to read metadata file: %w", err)
+	}
+
+	if err := yaml.Unmarshal(data, &all); err != nil {
+		return nil, fmt.Errorf("failed to parse metadata file %s: %w", s.path, err)
+	}
+	return all, nil
+}
+
+func (s *FileStore) write(all map[string]*domain.AccessKeyMetadata) error {
+	data, err := yaml.Marshal(all)
+	if err != nil {
+		return fmt.Errorf("failed to marshal metadata: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o700); err != nil {
+		return fmt.Errorf("failed to create metadata directory: %w", err)
+	}
+
+	// Write to a temporary file first so a crash never leaves a truncated
+	// file. The name is unique so that concurrent writers do not share it,
+	// and CreateTemp restricts it to the owner like the config file.
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), "."+filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write metadata file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write metadata file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write metadata file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to write metadata file: %w", err)
+	}
+	return nil
+}
+
+// ServerStore keeps metadata on the EnSync server, for deployments whose API
+// exposes the access key metadata endpoints
+type ServerStore struct {
+	client *api.Client
+}
+
+func NewServerStore(client *api.Client) *ServerStore {
+	return &ServerStore{client: client}
+}
+
+func (s *ServerStore) Load(ctx context.Context) (map[string]*domain.AccessKeyMetadata, error) {
+	all, err := s.client.ListAccessKeyMetadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if all == nil {
+		all = map[string]*domain.AccessKeyMetadata{}
+	}
+	return all, nil
+}
+
+func (s *ServerStore) Save(ctx context.Context, key string, metadata *domain.AccessKeyMetadata) error {
+	return s.client.SetAccessKeyMetadata(ctx, key, metadata)
+}
+
+func (s *ServerStore) Delete(ctx context.Context, key string) error {
+	return s.client.DeleteAccessKeyMetadata(ctx, key)
+}
+
+// Attach fills in the metadata of every key that the server did not already
+// return metadata for
+func Attach(keys []*domain.AccessKeyPermissions, all map[string]*domain.AccessKeyMetadata) {
+	for _, key := range keys {
+		if key.Metadata == nil {
+			key.Metadata = all[key.Key]
+		}
+	}
+}
@@ -0,0 +1,23 @@
+//go:build unix
+
+package ratelimit
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile takes an exclusive lock on file, released when the file is closed
+// or the process exits
+func lockFile(file *os.File) error {
+	for {
+		err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX)
+		if err != syscall.EINTR {
+			return err
+		}
+	}
+}
+
+func unlockFile(file *os.File) error {
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+}
@@ -1 +1,309 @@
+// Package ratelimit spaces out API requests, adapting to the budget the
+// server announces in its rate limit headers
 package ratelimit
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+)
+
+// Headers announcing the budget of the client
+const (
+	RemainingHeader = "X-RateLimit-Remaining"
+	ResetHeader     = "X-RateLimit-Reset"
+)
+
+// Defaults of buckets without limits of their own
+const (
+	DefaultRate  = 10
+	DefaultBurst = 20
+)
+
+// minRate is the slowest the limiter goes when adapting, so that it keeps
+// probing the server
+const minRate = 0.1
+
+// Bucket groups the requests sharing a budget
+type Bucket string
+
+const (
+	Read  Bucket = "read"
+	Write Bucket = "write"
+)
+
+// BucketFor returns the bucket of requests with method: reads for GET, HEAD
+// and OPTIONS, writes otherwise
+func BucketFor(method string) Bucket {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return Read
+	default:
+		return Write
+	}
+}
+
+// Limits configures a bucket
+type Limits struct {
+	// Rate is the initial number of requests per second, 0 for no limit
+	// until the server announces one
+	Rate float64
+	// Burst is the number of requests sent at once before Rate applies
+	Burst int
+	// Max caps the rate the limiter speeds up to, 0 for no cap
+	Max float64
+}
+
+// Config configures a Limiter
+type Config struct {
+	// Default applies to buckets missing from Buckets
+	Default Limits
+	Buckets map[Bucket]Limits
+	// Adaptive follows X-RateLimit-Remaining and X-RateLimit-Reset. A 429
+	// pauses the bucket either way.
+	Adaptive bool
+	// SharedDir, when set, keeps the buckets in files of the directory so
+	// that every process using it shares one budget
+	SharedDir string
+	Logger    *zap.Logger
+}
+
+// Limiter holds a token bucket per Bucket
+type Limiter struct {
+	config Config
+	logger *zap.Logger
+
+	mu      sync.Mutex
+	buckets map[Bucket]*bucket
+}
+
+type bucket struct {
+	name    Bucket
+	limits  Limits
+	limiter *rate.Limiter
+	// pausedUntil holds requests back after the server ran out of budget
+	pausedUntil time.Time
+	// shared takes the tokens instead of limiter when the budget is shared
+	// with other processes. limiter still holds the rate and burst.
+	shared *sharedBucket
+}
+
+// New returns a limiter for config
+func New(config Config) *Limiter {
+	logger := config.Logger
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	return &Limiter{config: config, logger: logger, buckets: map[Bucket]*bucket{}}
+}
+
+// bucket returns the bucket of name, creating it on first use. l.mu must be
+// held.
+func (l *Limiter) bucket(name Bucket) *bucket {
+	if b, ok := l.buckets[name]; ok {
+		return b
+	}
+
+	limits, ok := l.config.Buckets[name]
+	if !ok {
+		limits = l.config.Default
+	}
+	if limits.Burst < 1 {
+		limits.Burst = 1
+	}
+
+	limit := rate.Inf
+	if limits.Rate > 0 {
+		limit = rate.Limit(limits.Rate)
+	}
+
+	b := &bucket{name: name, limits: limits, limiter: rate.NewLimiter(limit, limits.Burst)}
+	if l.config.SharedDir != "" {
+		b.shared = newSharedBucket(l.config.SharedDir, name)
+	}
+	l.buckets[name] = b
+	return b
+}
+
+// Wait blocks until a request of the bucket may be sent
+func (l *Limiter) Wait(ctx context.Context, name Bucket) error {
+	l.mu.Lock()
+	b := l.bucket(name)
+	l.mu.Unlock()
+
+	for {
+		l.mu.Lock()
+		wait := time.Until(b.pausedUntil)
+		l.mu.Unlock()
+		if wait <= 0 {
+			break
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+
+	if b.shared != nil {
+		return b.shared.take(ctx, float64(b.limiter.Limit()), b.limiter.Burst())
+	}
+	return b.limiter.Wait(ctx)
+}
+
+// Observe adapts the bucket to a response of the server
+func (l *Limiter) Observe(name Bucket, resp *http.Response) {
+	if resp == nil {
+		return
+	}
+
+	now := time.Now()
+	remaining, hasRemaining := parseRemaining(resp.Header)
+	reset, hasReset := parseReset(resp.Header, now)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b := l.bucket(name)
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		wait, ok := retryAfter(resp.Header, now)
+		if !ok && hasReset {
+			wait, ok = reset, true
+		}
+		if !ok || wait <= 0 {
+			wait = time.Second
+		}
+		l.pause(b, now.Add(wait))
+
+		if l.config.Adaptive {
+			current := float64(b.limiter.Limit())
+			if math.IsInf(current, 1) {
+				current = DefaultRate
+			}
+			l.setRate(b, current/2, b.limiter.Burst())
+		}
+		return
+	}
+
+	if !l.config.Adaptive || !hasRemaining {
+		return
+	}
+
+	if remaining == 0 {
+		if hasReset {
+			l.pause(b, now.Add(reset))
+		}
+		return
+	}
+
+	if !hasReset || reset <= 0 {
+		return
+	}
+
+	// Spread the remaining budget evenly until the window resets, and never
+	// send more at once than the server still accepts
+	l.setRate(b, float64(remaining)/reset.Seconds(), min(b.limits.Burst, remaining))
+}
+
+// pause holds the requests of the bucket back until until. l.mu must be
+// held.
+func (l *Limiter) pause(b *bucket, until time.Time) {
+	if until.After(b.pausedUntil) {
+		b.pausedUntil = until
+		l.logger.Debug("Rate limited by the server, pausing requests",
+			zap.String("bucket", string(b.name)),
+			zap.Duration("wait", time.Until(until)),
+		)
+	}
+
+	if b.shared != nil {
+		if err := b.shared.pause(until); err != nil {
+			l.logger.Debug("Failed to share rate limit pause", zap.Error(err))
+		}
+	}
+}
+
+// setRate changes the rate and burst of the bucket within its limits. l.mu
+// must be held.
+func (l *Limiter) setRate(b *bucket, perSecond float64, burst int) {
+	perSecond = max(perSecond, minRate)
+	if b.limits.Max > 0 {
+		perSecond = min(perSecond, b.limits.Max)
+	}
+	burst = max(burst, 1)
+
+	previous := b.limiter.Limit()
+	if math.Abs(float64(previous)-perSecond) < 0.01 && b.limiter.Burst() == burst {
+		return
+	}
+
+	b.limiter.SetLimit(rate.Limit(perSecond))
+	b.limiter.SetBurst(burst)
+	l.logger.Debug("Adjusted rate limit",
+		zap.String("bucket", string(b.name)),
+		zap.Float64("previous", float64(previous)),
+		zap.Float64("rate", perSecond),
+		zap.Int("burst", burst),
+	)
+}
+
+// Rate returns the current rate of the bucket in requests per second
+func (l *Limiter) Rate(name Bucket) float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return float64(l.bucket(name).limiter.Limit())
+}
+
+func parseRemaining(header http.Header) (int, bool) {
+	value := strings.TrimSpace(header.Get(RemainingHeader))
+	if value == "" {
+		return 0, false
+	}
+	remaining, err := strconv.Atoi(value)
+	if err != nil || remaining < 0 {
+		return 0, false
+	}
+	return remaining, true
+}
+
+// parseReset returns the time until the budget resets. Servers send either
+// the seconds left or a Unix timestamp, told apart by their size.
+func parseReset(header http.Header, now time.Time) (time.Duration, bool) {
+	value := strings.TrimSpace(header.Get(ResetHeader))
+	if value == "" {
+		return 0, false
+	}
+	seconds, err := strconv.ParseFloat(value, 64)
+	if err != nil || seconds < 0 {
+		return 0, false
+	}
+
+	if seconds > 1e9 {
+		return max(time.Unix(int64(seconds), 0).Sub(now), 0), true
+	}
+	return time.Duration(seconds * float64(time.Second)), true
+}
+
+func retryAfter(header http.Header, now time.Time) (time.Duration, bool) {
+	value := strings.TrimSpace(header.Get("Retry-After"))
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return date.Sub(now), true
+	}
+	return 0, false
+}
@@ -0,0 +1,131 @@
+package ratelimit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// sharedBucket is a token bucket kept in a file, so that every process using
+// it shares one budget. The file is locked only while its state is read and
+// written, never while waiting, and the lock is released by the kernel when
+// a process dies, so a crashed process cannot block the others.
+type sharedBucket struct {
+	path string
+}
+
+// sharedState is the content of the file of a shared bucket
+type sharedState struct {
+	// Tokens is the number of requests that may be sent right away, as of
+	// Updated
+	Tokens  float64   `json:"tokens"`
+	Updated time.Time `json:"updated"`
+	// PausedUntil holds the requests of every process back after the server
+	// ran out of budget
+	PausedUntil time.Time `json:"paused_until"`
+}
+
+func newSharedBucket(dir string, name Bucket) *sharedBucket {
+	return &sharedBucket{path: filepath.Join(dir, string(name)+".json")}
+}
+
+// take waits until a token is available at perSecond requests per second
+// and burst at once, and takes it
+func (b *sharedBucket) take(ctx context.Context, perSecond float64, burst int) error {
+	for {
+		var wait time.Duration
+		err := b.update(func(state *sharedState, now time.Time) {
+			if state.PausedUntil.After(now) {
+				wait = state.PausedUntil.Sub(now)
+				return
+			}
+			if math.IsInf(perSecond, 1) {
+				return
+			}
+
+			elapsed := now.Sub(state.Updated).Seconds()
+			state.Tokens = math.Min(float64(burst), state.Tokens+max(elapsed, 0)*perSecond)
+			state.Updated = now
+
+			if state.Tokens >= 1 {
+				state.Tokens--
+				return
+			}
+			wait = time.Duration((1 - state.Tokens) / perSecond * float64(time.Second))
+		})
+		if err != nil {
+			return err
+		}
+		if wait <= 0 {
+			return nil
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// pause holds the requests of every process back until until
+func (b *sharedBucket) pause(until time.Time) error {
+	return b.update(func(state *sharedState, _ time.Time) {
+		if until.After(state.PausedUntil) {
+			state.PausedUntil = until
+		}
+	})
+}
+
+// update applies fn to the state of the bucket with the file locked
+func (b *sharedBucket) update(fn func(state *sharedState, now time.Time)) error {
+	if err := os.MkdirAll(filepath.Dir(b.path), 0o700); err != nil {
+		return fmt.Errorf("failed to create rate limit directory: %w", err)
+	}
+
+	file, err := os.OpenFile(b.path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open rate limit file: %w", err)
+	}
+	defer file.Close()
+
+	if err := lockFile(file); err != nil {
+		return fmt.Errorf("failed to lock rate limit file: %w", err)
+	}
+	defer unlockFile(file)
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return fmt.Errorf("failed to read rate limit file: %w", err)
+	}
+
+	// A new file, or one left half written, has never been updated and so
+	// refills to a full bucket
+	var state sharedState
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &state); err != nil {
+			state = sharedState{}
+		}
+	}
+
+	fn(&state, time.Now())
+
+	data, err = json.Marshal(&state)
+	if err != nil {
+		return fmt.Errorf("failed to encode rate limit state: %w", err)
+	}
+	if err := file.Truncate(0); err != nil {
+		return fmt.Errorf("failed to write rate limit file: %w", err)
+	}
+	if _, err := file.WriteAt(data, 0); err != nil {
+		return fmt.Errorf("failed to write rate limit file: %w", err)
+	}
+	return nil
+}
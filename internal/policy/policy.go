@@ -0,0 +1,214 @@
+// Package policy evaluates access key permissions against a set of rules
+// kept in a policy file.
+package policy
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/rossi1/ensync-cli/internal/domain"
+	"github.com/rossi1/ensync-cli/internal/label"
+	"github.com/rossi1/ensync-cli/pkg/permission"
+)
+
+// Rule types supported in a policy file
+const (
+	// RuleNoSendAndReceive forbids a key to both send and receive events
+	// matching Events
+	RuleNoSendAndReceive = "no-send-and-receive"
+	// RuleReceiveOnly forbids a key to have any send permission
+	RuleReceiveOnly = "receive-only"
+	// RuleSendOnly forbids a key to have any receive permission
+	RuleSendOnly = "send-only"
+	// RuleDenySend forbids a key to send events matching Events
+	RuleDenySend = "deny-send"
+	// RuleDenyReceive forbids a key to receive events matching Events
+	RuleDenyReceive = "deny-receive"
+	// RuleMaxSend limits the number of send permissions of a key to Max
+	RuleMaxSend = "max-send"
+	// RuleMaxReceive limits the number of receive permissions of a key to Max
+	RuleMaxReceive = "max-receive"
+)
+
+// Policy is a set of rules loaded from a policy file
+type Policy struct {
+	Rules []Rule `yaml:"rules" json:"rules"`
+}
+
+// Rule is a single check applied to every access key it selects
+type Rule struct {
+	ID          string `yaml:"id" json:"id"`
+	Description string `yaml:"description,omitempty" json:"description,omitempty"`
+	Type        string `yaml:"type" json:"type"`
+	// Events is an event name or prefix pattern for rules that target events
+	Events string `yaml:"events,omitempty" json:"events,omitempty"`
+	// Max is the limit for max-send and max-receive rules. It must be set
+	// explicitly, since a missing limit of 0 would flag every key.
+	Max *int `yaml:"max,omitempty" json:"max,omitempty"`
+	// Label is a label selector, such as "external" or "team=payments",
+	// restricting the rule to the keys it matches
+	Label string `yaml:"label,omitempty" json:"label,omitempty"`
+
+	selector label.Selector
+}
+
+// Subject is an access key with the permissions to check
+type Subject struct {
+	Key         string
+	Labels      map[string]string
+	Permissions *domain.Permissions
+}
+
+// Violation is a rule broken by an access key
+type Violation struct {
+	RuleID  string `json:"rule"`
+	Key     string `json:"key"`
+	Message string `json:"message"`
+}
+
+// Load reads and validates a YAML or JSON policy file
+func Load(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy file: %w", err)
+	}
+
+	var p Policy
+	if err := yaml.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("failed to parse policy file: %w", err)
+	}
+
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &p, nil
+}
+
+// Validate checks that every rule is well formed and has a unique ID
+func (p *Policy) Validate() error {
+	seen := make(map[string]bool, len(p.Rules))
+
+	for i := range p.Rules {
+		rule := &p.Rules[i]
+		if rule.ID == "" {
+			return fmt.Errorf("rule %d: id is required", i+1)
+		}
+		if seen[rule.ID] {
+			return fmt.Errorf("rule %s: duplicate id", rule.ID)
+		}
+		seen[rule.ID] = true
+
+		switch rule.Type {
+		case RuleNoSendAndReceive, RuleDenySend, RuleDenyReceive:
+			if rule.Events == "" {
+				return fmt.Errorf("rule %s: events is required for %s", rule.ID, rule.Type)
+			}
+		case RuleMaxSend, RuleMaxReceive:
+			if rule.Max == nil {
+				return fmt.Errorf("rule %s: max is required for %s", rule.ID, rule.Type)
+			}
+			if *rule.Max < 0 {
+				return fmt.Errorf("rule %s: max must not be negative", rule.ID)
+			}
+		case RuleReceiveOnly, RuleSendOnly:
+		default:
+			return fmt.Errorf("rule %s: unknown type %q", rule.ID, rule.Type)
+		}
+
+		selector, err := label.ParseSelector(rule.Label)
+		if err != nil {
+			return fmt.Errorf("rule %s: %w", rule.ID, err)
+		}
+		rule.selector = selector
+	}
+
+	return nil
+}
+
+// Check evaluates every rule against every subject. Violations are sorted
+// by key and rule ID.
+func (p *Policy) Check(subjects []Subject) []Violation {
+	violations := []Violation{}
+
+	for _, subject := range subjects {
+		permissions := subject.Permissions
+		if permissions == nil {
+			permissions = &domain.Permissions{}
+		}
+
+		for _, rule := range p.Rules {
+			if !rule.selector.Matches(subject.Labels) {
+				continue
+			}
+			if msg := rule.check(permissions); msg != "" {
+				violations = append(violations, Violation{
+					RuleID:  rule.ID,
+					Key:     subject.Key,
+					Message: msg,
+				})
+			}
+		}
+	}
+
+	sort.SliceStable(violations, func(i, j int) bool {
+		if violations[i].Key != violations[j].Key {
+			return violations[i].Key < violations[j].Key
+		}
+		return violations[i].RuleID < violations[j].RuleID
+	})
+
+	return violations
+}
+
+// check returns a description of the violation, or an empty string when the
+// permissions comply with the rule
+func (r Rule) check(p *domain.Permissions) string {
+	switch r.Type {
+	case RuleNoSendAndReceive:
+		send := overlapping(p.Send, r.Events)
+		receive := overlapping(p.Receive, r.Events)
+		if len(send) > 0 && len(receive) > 0 {
+			return fmt.Sprintf("both sends %v and receives %v matching %s", send, receive, r.Events)
+		}
+	case RuleReceiveOnly:
+		if len(p.Send) > 0 {
+			return fmt.Sprintf("may only receive but sends %v", p.Send)
+		}
+	case RuleSendOnly:
+		if len(p.Receive) > 0 {
+			return fmt.Sprintf("may only send but receives %v", p.Receive)
+		}
+	case RuleDenySend:
+		if send := overlapping(p.Send, r.Events); len(send) > 0 {
+			return fmt.Sprintf("sends %v matching %s", send, r.Events)
+		}
+	case RuleDenyReceive:
+		if receive := overlapping(p.Receive, r.Events); len(receive) > 0 {
+			return fmt.Sprintf("receives %v matching %s", receive, r.Events)
+		}
+	case RuleMaxSend:
+		if r.Max != nil && len(p.Send) > *r.Max {
+			return fmt.Sprintf("has %d send permissions, more than the maximum of %d", len(p.Send), *r.Max)
+		}
+	case RuleMaxReceive:
+		if r.Max != nil && len(p.Receive) > *r.Max {
+			return fmt.Sprintf("has %d receive permissions, more than the maximum of %d", len(p.Receive), *r.Max)
+		}
+	}
+	return ""
+}
+
+// overlapping returns the entries that can grant an event matching pattern
+func overlapping(entries []string, pattern string) []string {
+	var result []string
+	for _, entry := range entries {
+		if permission.Overlaps(entry, pattern) {
+			result = append(result, entry)
+		}
+	}
+	return result
+}
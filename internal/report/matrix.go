@@ -0,0 +1,84 @@
+// Package report renders access key permission reports.
+package report
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/rossi1/ensync-cli/internal/domain"
+	"github.com/rossi1/ensync-cli/pkg/permission"
+)
+
+// Row is one access key in a permission matrix
+type Row struct {
+	Key   string
+	Label string
+	Cells []Cell
+}
+
+// Cell holds the permissions a key has on one event
+type Cell struct {
+	Send    bool
+	Receive bool
+}
+
+// Mark returns the short marker used for a cell in every output format
+func (c Cell) Mark() string {
+	var b strings.Builder
+	if c.Send {
+		b.WriteString("S")
+	}
+	if c.Receive {
+		b.WriteString("R")
+	}
+	return b.String()
+}
+
+// Matrix is a grid of access keys against events
+type Matrix struct {
+	Events []string
+	Rows   []Row
+}
+
+// MatrixOptions controls which events and labels go into a matrix
+type MatrixOptions struct {
+	// Prefix keeps only the events whose name starts with it
+	Prefix string
+	// Labels maps access keys to human readable labels
+	Labels map[string]string
+}
+
+// NewMatrix builds the permission grid of keys against events. Permission
+// entries that are prefix patterns mark every event they match.
+func NewMatrix(keys []*domain.AccessKeyPermissions, events []*domain.Event, opts MatrixOptions) *Matrix {
+	m := &Matrix{}
+	for _, event := range events {
+		if strings.HasPrefix(event.Name, opts.Prefix) {
+			m.Events = append(m.Events, event.Name)
+		}
+	}
+	sort.Strings(m.Events)
+
+	for _, key := range keys {
+		row := Row{
+			Key:   key.Key,
+			Label: opts.Labels[key.Key],
+			Cells: make([]Cell, len(m.Events)),
+		}
+
+		if key.Permissions != nil {
+			for i, name := range m.Events {
+				_, row.Cells[i].Send = permission.MatchAny(key.Permissions.Send, name)
+				_, row.Cells[i].Receive = permission.MatchAny(key.Permissions.Receive, name)
+			}
+		}
+
+		m.Rows = append(m.Rows, row)
+	}
+
+	sort.Slice(m.Rows, func(i, j int) bool {
+		return m.Rows[i].Key < m.Rows[j].Key
+	})
+
+	return m
+}
@@ -0,0 +1,147 @@
+package report
+
+import (
+	"encoding/csv"
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+	"time"
+)
+
+// Supported output formats
+const (
+	FormatCSV      = "csv"
+	FormatMarkdown = "md"
+	FormatHTML     = "html"
+)
+
+// CheckFormat returns an error when the matrix cannot be rendered in format
+func CheckFormat(format string) error {
+	switch format {
+	case FormatCSV, FormatMarkdown, FormatHTML:
+		return nil
+	default:
+		return fmt.Errorf("unsupported format %q (expected %s, %s or %s)", format, FormatCSV, FormatMarkdown, FormatHTML)
+	}
+}
+
+// Write renders the matrix in the given format
+func (m *Matrix) Write(w io.Writer, format string) error {
+	switch format {
+	case FormatCSV:
+		return m.WriteCSV(w)
+	case FormatMarkdown:
+		return m.WriteMarkdown(w)
+	case FormatHTML:
+		return m.WriteHTML(w)
+	default:
+		return CheckFormat(format)
+	}
+}
+
+func (m *Matrix) header() []string {
+	return append([]string{"key", "label"}, m.Events...)
+}
+
+func (r Row) record() []string {
+	record := []string{r.Key, r.Label}
+	for _, cell := range r.Cells {
+		record = append(record, cell.Mark())
+	}
+	return record
+}
+
+// WriteCSV renders the matrix as CSV with one row per key
+func (m *Matrix) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(m.header()); err != nil {
+		return err
+	}
+	for _, row := range m.Rows {
+		if err := cw.Write(row.record()); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteMarkdown renders the matrix as a GitHub flavored Markdown table
+func (m *Matrix) WriteMarkdown(w io.Writer) error {
+	header := m.header()
+
+	var b strings.Builder
+	writeMarkdownRow(&b, header)
+
+	separator := make([]string, len(header))
+	for i := range separator {
+		separator[i] = "---"
+		if i >= 2 {
+			separator[i] = ":---:"
+		}
+	}
+	writeMarkdownRow(&b, separator)
+
+	for _, row := range m.Rows {
+		writeMarkdownRow(&b, row.record())
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func writeMarkdownRow(b *strings.Builder, cells []string) {
+	b.WriteString("|")
+	for _, cell := range cells {
+		b.WriteString(" ")
+		b.WriteString(strings.ReplaceAll(cell, "|", `\|`))
+		b.WriteString(" |")
+	}
+	b.WriteString("\n")
+}
+
+var htmlTemplate = template.Must(template.New("matrix").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>EnSync permission matrix</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; margin: 2em; color: #1f2328; }
+table { border-collapse: collapse; font-size: 0.9em; }
+th, td { border: 1px solid #d0d7de; padding: 4px 8px; }
+th { background: #f6f8fa; position: sticky; top: 0; }
+thead th.event { writing-mode: vertical-rl; transform: rotate(180deg); white-space: nowrap; }
+td.mark { text-align: center; font-weight: 600; }
+td.S { background: #ddf4ff; }
+td.R { background: #dafbe1; }
+td.SR { background: #fff8c5; }
+tbody tr:hover { background: #f6f8fa; }
+.legend { margin: 1em 0; color: #59636e; }
+</style>
+</head>
+<body>
+<h1>EnSync permission matrix</h1>
+<p class="legend">Generated {{.Generated}}. {{len .Matrix.Rows}} access keys, {{len .Matrix.Events}} events. S = send, R = receive.</p>
+<table>
+<thead>
+<tr><th>Key</th><th>Label</th>{{range .Matrix.Events}}<th class="event">{{.}}</th>{{end}}</tr>
+</thead>
+<tbody>
+{{range .Matrix.Rows}}<tr><td><code>{{.Key}}</code></td><td>{{.Label}}</td>{{range .Cells}}<td class="mark {{.Mark}}">{{.Mark}}</td>{{end}}</tr>
+{{end}}</tbody>
+</table>
+</body>
+</html>
+`))
+
+// WriteHTML renders the matrix as a self-contained HTML page with inline styles
+func (m *Matrix) WriteHTML(w io.Writer) error {
+	return htmlTemplate.Execute(w, struct {
+		Generated string
+		Matrix    *Matrix
+	}{
+		Generated: time.Now().UTC().Format(time.RFC3339),
+		Matrix:    m,
+	})
+}
@@ -0,0 +1,144 @@
+// Package role resolves named permission bundles defined in the config and
+// tracks which access keys were granted which roles.
+package role
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/rossi1/ensync-cli/internal/domain"
+)
+
+// AssignmentsFile is the name of the file, inside the config dir, recording
+// the roles granted to each access key
+const AssignmentsFile = "role-assignments.yaml"
+
+// Resolve merges the named roles into a single set of permissions. Role
+// names are case-insensitive, since the config loader lower-cases them.
+func Resolve(defs map[string]*domain.Permissions, names []string) (*domain.Permissions, error) {
+	merged := &domain.Permissions{Send: []string{}, Receive: []string{}}
+	for _, name := range names {
+		def, ok := Lookup(defs, name)
+		if !ok {
+			return nil, fmt.Errorf("unknown role %q", name)
+		}
+		merged = merged.Merge(def)
+	}
+	return merged, nil
+}
+
+// SameName reports whether two role names refer to the same role
+func SameName(a, b string) bool {
+	return strings.EqualFold(a, b)
+}
+
+// Lookup returns the definition of a role, matching its name
+// case-insensitively
+func Lookup(defs map[string]*domain.Permissions, name string) (*domain.Permissions, bool) {
+	if def, ok := defs[name]; ok {
+		return def, true
+	}
+	for defName, def := range defs {
+		if SameName(defName, name) {
+			return def, true
+		}
+	}
+	return nil, false
+}
+
+// Assignments maps access keys to the roles they were granted
+type Assignments map[string][]string
+
+// LoadAssignments reads the assignments file. A missing file is empty.
+func LoadAssignments(path string) (Assignments, error) {
+	assignments := Assignments{}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return assignments, nil
+		}
+		return nil, fmt.Errorf("failed to read role assignments: %w", err)
+	}
+
+	if err := yaml.Unmarshal(data, &assignments); err != nil {
+		return nil, fmt.Errorf("failed to parse role assignments: %w", err)
+	}
+	return assignments, nil
+}
+
+// Save writes the assignments file, creating its directory if needed
+func (a Assignments) Save(path string) error {
+	data, err := yaml.Marshal(a)
+	if err != nil {
+		return fmt.Errorf("failed to marshal role assignments: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("failed to create config directory: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write role assignments: %w", err)
+	}
+	return nil
+}
+
+// Keys returns the assigned access keys in sorted order
+func (a Assignments) Keys() []string {
+	keys := make([]string, 0, len(a))
+	for key := range a {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Drift is the difference between the permissions a key should have
+// according to its roles and the permissions it actually has
+type Drift struct {
+	MissingSend    []string `json:"missingSend,omitempty"`
+	ExtraSend      []string `json:"extraSend,omitempty"`
+	MissingReceive []string `json:"missingReceive,omitempty"`
+	ExtraReceive   []string `json:"extraReceive,omitempty"`
+}
+
+// InSync reports whether the key matches its roles exactly
+func (d *Drift) InSync() bool {
+	return len(d.MissingSend) == 0 && len(d.ExtraSend) == 0 &&
+		len(d.MissingReceive) == 0 && len(d.ExtraReceive) == 0
+}
+
+// Diff compares the wanted permissions with the current ones
+func Diff(want, have *domain.Permissions) *Drift {
+	if have == nil {
+		have = &domain.Permissions{}
+	}
+	return &Drift{
+		MissingSend:    difference(want.Send, have.Send),
+		ExtraSend:      difference(have.Send, want.Send),
+		MissingReceive: difference(want.Receive, have.Receive),
+		ExtraReceive:   difference(have.Receive, want.Receive),
+	}
+}
+
+// difference returns the entries of a that are not in b
+func difference(a, b []string) []string {
+	in := make(map[string]bool, len(b))
+	for _, entry := range b {
+		in[entry] = true
+	}
+
+	var result []string
+	for _, entry := range a {
+		if !in[entry] {
+			result = append(result, entry)
+		}
+	}
+	return result
+}
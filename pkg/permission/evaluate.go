@@ -0,0 +1,142 @@
+package permission
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Direction is the kind of access a permission entry grants
+type Direction string
+
+const (
+	Send    Direction = "send"
+	Receive Direction = "receive"
+)
+
+// ParseDirection parses "send" or "receive"
+func ParseDirection(s string) (Direction, error) {
+	switch d := Direction(strings.ToLower(s)); d {
+	case Send, Receive:
+		return d, nil
+	default:
+		return "", fmt.Errorf("invalid direction %q (expected %s or %s)", s, Send, Receive)
+	}
+}
+
+func (d Direction) opposite() Direction {
+	if d == Send {
+		return Receive
+	}
+	return Send
+}
+
+// Set is the send and receive entries of an access key or a role
+type Set struct {
+	Send    []string `json:"send"`
+	Receive []string `json:"receive"`
+}
+
+// Entries returns the entries granting the given direction
+func (s Set) Entries(d Direction) []string {
+	if d == Send {
+		return s.Send
+	}
+	return s.Receive
+}
+
+// Decision is the result of evaluating a permission, with an explanation of
+// which entry granted it or why nothing did
+type Decision struct {
+	Allowed   bool      `json:"allowed"`
+	Direction Direction `json:"direction"`
+	Event     string    `json:"event"`
+	// Entry is the permission entry that granted access
+	Entry string `json:"entry,omitempty"`
+	// Pattern reports whether Entry is a prefix pattern
+	Pattern bool `json:"pattern,omitempty"`
+	// Roles lists the roles that contain Entry
+	Roles  []string `json:"roles,omitempty"`
+	Reason string   `json:"reason"`
+}
+
+// Evaluator decides whether a set of permissions allows an operation
+type Evaluator struct {
+	roles map[string]Set
+}
+
+// Option configures an Evaluator
+type Option func(*Evaluator)
+
+// WithRoles lets the evaluator attribute granting entries to the roles that
+// contain them
+func WithRoles(roles map[string]Set) Option {
+	return func(e *Evaluator) {
+		e.roles = roles
+	}
+}
+
+func NewEvaluator(opts ...Option) *Evaluator {
+	e := &Evaluator{}
+	for _, opt := range opts {
+		opt(e)
+	}
+	return e
+}
+
+// Evaluate decides whether set allows the direction on the event
+func Evaluate(set Set, d Direction, event string) Decision {
+	return NewEvaluator().Evaluate(set, d, event)
+}
+
+// Evaluate decides whether set allows the direction on the event. Exact
+// entries take precedence over prefix patterns.
+func (e *Evaluator) Evaluate(set Set, d Direction, event string) Decision {
+	decision := Decision{Direction: d, Event: event}
+
+	if entry, ok := MatchAny(set.Entries(d), event); ok {
+		decision.Allowed = true
+		decision.Entry = entry
+		decision.Pattern = IsPattern(entry)
+		decision.Roles = e.rolesGranting(d, entry)
+
+		switch {
+		case decision.Pattern:
+			decision.Reason = fmt.Sprintf("%s entry %q matches %q by prefix", d, entry, event)
+		default:
+			decision.Reason = fmt.Sprintf("%s entry %q matches exactly", d, entry)
+		}
+		if len(decision.Roles) > 0 {
+			decision.Reason += fmt.Sprintf(", granted by role %s", strings.Join(decision.Roles, ", "))
+		}
+		return decision
+	}
+
+	entries := set.Entries(d)
+	if len(entries) == 0 {
+		decision.Reason = fmt.Sprintf("no %s permissions", d)
+	} else {
+		decision.Reason = fmt.Sprintf("none of the %d %s entries match %q", len(entries), d, event)
+	}
+
+	if entry, ok := MatchAny(set.Entries(d.opposite()), event); ok {
+		decision.Reason += fmt.Sprintf("; %s entry %q only grants %s", d.opposite(), entry, d.opposite())
+	}
+
+	return decision
+}
+
+// rolesGranting returns the sorted names of the roles holding the entry
+func (e *Evaluator) rolesGranting(d Direction, entry string) []string {
+	var roles []string
+	for name, set := range e.roles {
+		for _, candidate := range set.Entries(d) {
+			if candidate == entry {
+				roles = append(roles, name)
+				break
+			}
+		}
+	}
+	sort.Strings(roles)
+	return roles
+}
@@ -0,0 +1,53 @@
+// Package permission evaluates EnSync access key permissions. It has no
+// dependency on the API client so it can be used by any Go program that
+// needs to answer "can this key send or receive that event".
+package permission
+
+import "strings"
+
+// Wildcard marks a permission entry as a prefix pattern when it is the last
+// character of the entry, e.g. "orders/*" matches "orders/created".
+const Wildcard = "*"
+
+// IsPattern reports whether the permission entry is a prefix pattern
+func IsPattern(entry string) bool {
+	return strings.HasSuffix(entry, Wildcard)
+}
+
+// Match reports whether the permission entry grants access to the named event
+func Match(entry, name string) bool {
+	if IsPattern(entry) {
+		return strings.HasPrefix(name, strings.TrimSuffix(entry, Wildcard))
+	}
+	return entry == name
+}
+
+// MatchAny returns the first entry that grants access to the named event.
+// Exact entries take precedence over patterns.
+func MatchAny(entries []string, name string) (string, bool) {
+	for _, entry := range entries {
+		if entry == name {
+			return entry, true
+		}
+	}
+	for _, entry := range entries {
+		if IsPattern(entry) && Match(entry, name) {
+			return entry, true
+		}
+	}
+	return "", false
+}
+
+// Overlaps reports whether two permission entries can grant access to at
+// least one common event name
+func Overlaps(a, b string) bool {
+	switch {
+	case IsPattern(a) && IsPattern(b):
+		pa, pb := strings.TrimSuffix(a, Wildcard), strings.TrimSuffix(b, Wildcard)
+		return strings.HasPrefix(pa, pb) || strings.HasPrefix(pb, pa)
+	case IsPattern(a):
+		return Match(a, b)
+	default:
+		return Match(b, a)
+	}
+}
@@ -0,0 +1,73 @@
+package integration
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type auditReport struct {
+	KeysScanned   int `json:"keysScanned"`
+	EventsScanned int `json:"eventsScanned"`
+	Dangling      []struct {
+		Key     string   `json:"key"`
+		Send    []string `json:"send"`
+		Receive []string `json:"receive"`
+	} `json:"dangling"`
+	UnusedEvents []string `json:"unusedEvents"`
+}
+
+func auditServer(t *testing.T) *fakeServer {
+	server := newFakeServer(t)
+	server.AddEvents("order.created", "order.paid", "user.created", "billing.charged")
+	server.AddKey("key-a", []string{"order.created", "order.deleted"}, []string{"order.*"})
+	server.AddKey("key-b", []string{"user.created"}, []string{"ghost.*"})
+	server.AddKey("key-c", []string{"order.paid"}, []string{})
+	return server
+}
+
+func TestAuditPermissions(t *testing.T) {
+	server := auditServer(t)
+	cli := newCLI(t, server)
+
+	var report auditReport
+	cli.RunJSON(&report, "audit", "permissions")
+
+	assert.Equal(t, 3, report.KeysScanned)
+	assert.Equal(t, 4, report.EventsScanned)
+	require.Len(t, report.Dangling, 2)
+	assert.Equal(t, "key-a", report.Dangling[0].Key)
+	assert.Equal(t, []string{"order.deleted"}, report.Dangling[0].Send)
+	assert.Empty(t, report.Dangling[0].Receive)
+	assert.Equal(t, "key-b", report.Dangling[1].Key)
+	assert.Equal(t, []string{"ghost.*"}, report.Dangling[1].Receive)
+	assert.Equal(t, []string{"billing.charged"}, report.UnusedEvents)
+}
+
+func TestAuditPermissionsFix(t *testing.T) {
+	server := auditServer(t)
+	cli := newCLI(t, server)
+
+	// Refusing the prompt changes nothing
+	_, stderr, err := cli.Run("n\n", "audit", "permissions", "--fix")
+	require.NoError(t, err)
+	assert.Contains(t, stderr, "Aborted")
+	assert.Equal(t, []string{"order.created", "order.deleted"}, server.Permissions("key-a").Send)
+
+	_, stderr, err = cli.Run("", "audit", "permissions", "--fix", "--yes")
+	require.NoError(t, err, stderr)
+	assert.Contains(t, stderr, "Pruned 1 dangling permission(s) from key-a")
+
+	assert.Equal(t, []string{"order.created"}, server.Permissions("key-a").Send)
+	assert.Equal(t, []string{"order.*"}, server.Permissions("key-a").Receive)
+	assert.Equal(t, []string{"user.created"}, server.Permissions("key-b").Send)
+	assert.Empty(t, server.Permissions("key-b").Receive)
+
+	// Keys without dangling permissions are left alone
+	assert.Zero(t, server.CountRequests("POST", "/access-key/permissions/key-c"))
+
+	var report auditReport
+	cli.RunJSON(&report, "audit", "permissions")
+	assert.Empty(t, report.Dangling)
+}
@@ -0,0 +1,147 @@
+package integration
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rossi1/ensync-cli/internal/api"
+)
+
+// switchableServer answers with the status held by status, counting the
+// requests
+func switchableServer(t *testing.T, status *atomic.Int32) (*httptest.Server, *atomic.Int32) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(int(status.Load()))
+		if status.Load() >= http.StatusBadRequest {
+			w.Write([]byte(`{"message": "backend unavailable"}`))
+			return
+		}
+		w.Write([]byte(`{"resultsLength": 0, "results": []}`))
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func TestCircuitBreakerOpensAndRecovers(t *testing.T) {
+	var status atomic.Int32
+	status.Store(http.StatusInternalServerError)
+	server, requests := switchableServer(t, &status)
+
+	client := api.NewClient(server.URL, "key",
+		api.WithRetryPolicy(fastRetries),
+		api.WithCircuitBreaker(api.BreakerConfig{Failures: 3, Window: time.Minute, CoolDown: 200 * time.Millisecond}),
+	)
+
+	// A call counts as one failure however many attempts it made
+	err := listEvents(client)
+	require.Error(t, err)
+	assert.NotErrorIs(t, err, api.ErrCircuitOpen)
+	assert.Equal(t, int32(3), requests.Load())
+	assert.Equal(t, api.CircuitClosed, client.CircuitBreaker().Stats().State)
+
+	// Three failed calls open the circuit
+	require.Error(t, listEvents(client))
+	require.Error(t, listEvents(client))
+	assert.Equal(t, int32(9), requests.Load())
+	assert.Equal(t, api.CircuitOpen, client.CircuitBreaker().Stats().State)
+
+	// and later requests fail fast without reaching the server or retrying
+	start := time.Now()
+	err = listEvents(client)
+	require.ErrorIs(t, err, api.ErrCircuitOpen)
+	assert.Less(t, time.Since(start), 50*time.Millisecond)
+	assert.Equal(t, int32(9), requests.Load())
+
+	// The trial call after the cool-down makes all its attempts, and opens
+	// the circuit again when they fail
+	time.Sleep(250 * time.Millisecond)
+	err = listEvents(client)
+	require.Error(t, err)
+	assert.NotErrorIs(t, err, api.ErrCircuitOpen)
+	assert.Equal(t, int32(12), requests.Load())
+	assert.Equal(t, api.CircuitOpen, client.CircuitBreaker().Stats().State)
+
+	// A successful trial closes it
+	status.Store(http.StatusOK)
+	time.Sleep(250 * time.Millisecond)
+	require.NoError(t, listEvents(client))
+	require.NoError(t, listEvents(client))
+
+	stats := client.CircuitBreaker().Stats()
+	assert.Equal(t, api.CircuitClosed, stats.State)
+	assert.Equal(t, 2, stats.Opened)
+	assert.Equal(t, 1, stats.Rejected)
+}
+
+func TestCircuitBreakerCountsCallsThatRecovered(t *testing.T) {
+	// Every call fails once before its retry succeeds
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if requests.Add(1)%2 == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"message": "try again later"}`))
+			return
+		}
+		w.Write([]byte(`{"resultsLength": 0, "results": []}`))
+	}))
+	t.Cleanup(server.Close)
+
+	client := api.NewClient(server.URL, "key",
+		api.WithRetryPolicy(fastRetries),
+		api.WithCircuitBreaker(api.BreakerConfig{Failures: 2, Window: time.Minute}),
+	)
+	for i := 0; i < 4; i++ {
+		require.NoError(t, listEvents(client))
+	}
+	assert.Equal(t, int32(8), requests.Load())
+	assert.Equal(t, api.CircuitClosed, client.CircuitBreaker().Stats().State)
+	assert.Zero(t, client.CircuitBreaker().Stats().Opened)
+}
+
+func TestCircuitBreakerIgnoresThrottling(t *testing.T) {
+	var status atomic.Int32
+	status.Store(http.StatusTooManyRequests)
+	server, requests := switchableServer(t, &status)
+
+	client := api.NewClient(server.URL, "key",
+		api.WithRetryPolicy(api.RetryPolicy{MaxAttempts: 1}),
+		api.WithCircuitBreaker(api.BreakerConfig{Failures: 2}),
+	)
+	for i := 0; i < 3; i++ {
+		err := listEvents(client)
+		require.Error(t, err)
+		assert.NotErrorIs(t, err, api.ErrCircuitOpen)
+	}
+	assert.Equal(t, int32(3), requests.Load())
+	assert.Equal(t, api.CircuitClosed, client.CircuitBreaker().Stats().State)
+}
+
+func TestCircuitBreakerWindow(t *testing.T) {
+	var status atomic.Int32
+	status.Store(http.StatusBadGateway)
+	server, _ := switchableServer(t, &status)
+
+	client := api.NewClient(server.URL, "key",
+		api.WithRetryPolicy(api.RetryPolicy{MaxAttempts: 1}),
+		api.WithCircuitBreaker(api.BreakerConfig{Failures: 2, Window: 50 * time.Millisecond}),
+	)
+
+	// Failures further apart than the window do not add up
+	require.Error(t, listEvents(client))
+	time.Sleep(100 * time.Millisecond)
+	require.Error(t, listEvents(client))
+	assert.Equal(t, api.CircuitClosed, client.CircuitBreaker().Stats().State)
+
+	require.Error(t, listEvents(client))
+	assert.Equal(t, api.CircuitOpen, client.CircuitBreaker().Stats().State)
+}
@@ -0,0 +1,184 @@
+package integration
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rossi1/ensync-cli/internal/domain"
+)
+
+type bulkReport struct {
+	Undo    bool `json:"undo"`
+	Changes []struct {
+		Key    string              `json:"key"`
+		Before *domain.Permissions `json:"before"`
+		After  *domain.Permissions `json:"after"`
+		Status string              `json:"status"`
+		Error  string              `json:"error"`
+	} `json:"changes"`
+}
+
+func readBulkReport(t *testing.T, path string) *bulkReport {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var report bulkReport
+	require.NoError(t, json.Unmarshal(data, &report))
+	return &report
+}
+
+func bulkServer(t *testing.T) *fakeServer {
+	server := newFakeServer(t)
+	server.AddEvents("orders/created", "orders/created.v2", "orders/paid")
+	server.AddKey("key-a", []string{}, []string{"orders/created"})
+	server.AddKey("key-b", []string{"orders/paid"}, []string{"orders/created", "orders/paid"})
+	server.AddKey("key-c", []string{"orders/created"}, []string{"orders/paid"})
+	return server
+}
+
+func TestBulkPermissionsDryRun(t *testing.T) {
+	server := bulkServer(t)
+	cli := newCLI(t, server)
+
+	stdout, stderr, err := cli.Run("", "access-key", "permissions", "bulk",
+		"--match-receive", "orders/created", "--add-receive", "orders/created.v2", "--dry-run")
+	require.NoError(t, err, stderr)
+	assert.Contains(t, stderr, "2 access key(s) would be changed")
+
+	var changes []struct {
+		Key string `json:"key"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(stdout), &changes))
+	require.Len(t, changes, 2)
+	assert.Equal(t, "key-a", changes[0].Key)
+	assert.Equal(t, "key-b", changes[1].Key)
+
+	assert.Equal(t, []string{"orders/created"}, server.Permissions("key-a").Receive)
+	assert.Zero(t, server.CountRequests("POST", "/access-key/permissions/key-a"))
+}
+
+func TestBulkPermissionsApplyAndUndo(t *testing.T) {
+	server := bulkServer(t)
+	cli := newCLI(t, server)
+	reportFile := filepath.Join(cli.WorkDir, "split.json")
+
+	// The report lists every change as pending before the first update
+	var once sync.Once
+	var pendingReport *bulkReport
+	server.Intercept(func(w http.ResponseWriter, r *http.Request) bool {
+		if r.Method == http.MethodPost {
+			once.Do(func() { pendingReport = readBulkReport(t, reportFile) })
+		}
+		return false
+	})
+
+	_, stderr, err := cli.Run("", "access-key", "permissions", "bulk",
+		"--match-receive", "orders/created", "--add-receive", "orders/created.v2", "--drop-receive", "orders/paid",
+		"--report", reportFile, "--yes")
+	require.NoError(t, err, stderr)
+	assert.Contains(t, stderr, "Bulk update finished: 2 applied, 0 reverted, 0 skipped, 0 failed")
+	assert.Contains(t, stderr, "Circuit breaker closed: opened 0 time(s), 0 request(s) failed fast")
+
+	require.NotNil(t, pendingReport)
+	require.Len(t, pendingReport.Changes, 2)
+	for _, change := range pendingReport.Changes {
+		assert.Equal(t, "pending", change.Status)
+	}
+
+	assert.Equal(t, []string{"orders/created", "orders/created.v2"}, server.Permissions("key-a").Receive)
+	assert.Equal(t, []string{"orders/created", "orders/created.v2"}, server.Permissions("key-b").Receive)
+	assert.Equal(t, []string{"orders/paid"}, server.Permissions("key-c").Receive)
+
+	report := readBulkReport(t, reportFile)
+	require.Len(t, report.Changes, 2)
+	for _, change := range report.Changes {
+		assert.Equal(t, "applied", change.Status)
+	}
+
+	undoFile := filepath.Join(cli.WorkDir, "undo.json")
+	_, stderr, err = cli.Run("", "access-key", "permissions", "bulk", "--undo", reportFile, "--report", undoFile, "--yes")
+	require.NoError(t, err, stderr)
+	assert.Contains(t, stderr, "Bulk update finished: 0 applied, 2 reverted, 0 skipped, 0 failed")
+
+	assert.Equal(t, []string{"orders/created"}, server.Permissions("key-a").Receive)
+	assert.Equal(t, []string{"orders/created", "orders/paid"}, server.Permissions("key-b").Receive)
+	assert.True(t, readBulkReport(t, undoFile).Undo)
+}
+
+func TestBulkPermissionsUndoInterrupted(t *testing.T) {
+	server := newFakeServer(t)
+	// key-a was updated before the interruption, with its entries reordered
+	// by the server; key-b was not reached; key-c was changed since
+	server.AddKey("key-a", []string{}, []string{"orders/created.v2", "orders/created"})
+	server.AddKey("key-b", []string{}, []string{"orders/created"})
+	server.AddKey("key-c", []string{"orders/paid"}, []string{"orders/created"})
+	cli := newCLI(t, server)
+
+	reportFile := filepath.Join(cli.WorkDir, "interrupted.json")
+	require.NoError(t, os.WriteFile(reportFile, []byte(`{
+  "createdAt": "2026-01-02T03:04:05Z",
+  "changes": [
+    {"key": "key-a", "before": {"send": [], "receive": ["orders/created"]}, "after": {"send": [], "receive": ["orders/created", "orders/created.v2"]}, "status": "pending"},
+    {"key": "key-b", "before": {"send": [], "receive": ["orders/created"]}, "after": {"send": [], "receive": ["orders/created", "orders/created.v2"]}, "status": "pending"},
+    {"key": "key-c", "before": {"send": [], "receive": ["orders/created"]}, "after": {"send": [], "receive": ["orders/created", "orders/created.v2"]}, "status": "applied"}
+  ]
+}`), 0o600))
+
+	_, stderr, err := cli.Run("", "access-key", "permissions", "bulk", "--undo", reportFile, "--yes",
+		"--report", filepath.Join(cli.WorkDir, "undo.json"))
+	require.NoError(t, err, stderr)
+	assert.Contains(t, stderr, "Skipping key-c: permissions changed since the bulk update")
+	assert.Contains(t, stderr, "Bulk update finished: 0 applied, 1 reverted, 1 skipped, 0 failed")
+
+	assert.Equal(t, []string{"orders/created"}, server.Permissions("key-a").Receive)
+	assert.Zero(t, server.CountRequests("POST", "/access-key/permissions/key-b"))
+	assert.Zero(t, server.CountRequests("POST", "/access-key/permissions/key-c"))
+}
+
+func TestBulkPermissionsFailure(t *testing.T) {
+	server := bulkServer(t)
+	server.Intercept(func(w http.ResponseWriter, r *http.Request) bool {
+		if r.Method != http.MethodPost || r.URL.Path != "/access-key/permissions/key-b" {
+			return false
+		}
+		writeFakeError(w, http.StatusBadRequest, "rejected")
+		return true
+	})
+	cli := newCLI(t, server)
+	reportFile := filepath.Join(cli.WorkDir, "report.json")
+
+	_, stderr, err := cli.Run("", "access-key", "permissions", "bulk",
+		"--match-receive", "orders/created", "--add-receive", "orders/created.v2", "--report", reportFile, "--yes")
+	require.Error(t, err)
+	assert.Contains(t, stderr, "1 access key(s) failed to update")
+
+	report := readBulkReport(t, reportFile)
+	require.Len(t, report.Changes, 2)
+	assert.Equal(t, "applied", report.Changes[0].Status)
+	assert.Equal(t, "failed", report.Changes[1].Status)
+	assert.Contains(t, report.Changes[1].Error, "rejected")
+}
+
+func TestBulkPermissionsFlagErrors(t *testing.T) {
+	server := bulkServer(t)
+	cli := newCLI(t, server)
+
+	_, stderr, err := cli.Run("", "access-key", "permissions", "bulk", "--add-send", "orders/paid")
+	require.Error(t, err)
+	assert.Contains(t, stderr, "at least one of --match-send, --match-receive or --selector is required")
+
+	for _, flag := range []string{"--add-send", "--drop-send", "--add-receive", "--drop-receive"} {
+		_, stderr, err := cli.Run("", "access-key", "permissions", "bulk", "--undo", "report.json", flag, "orders/paid")
+		require.Error(t, err, flag)
+		assert.Contains(t, stderr, "if any flags in the group", flag)
+	}
+
+	assert.Empty(t, server.Requests())
+}
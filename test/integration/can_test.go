@@ -0,0 +1,82 @@
+package integration
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rossi1/ensync-cli/pkg/permission"
+)
+
+func TestAccessKeyCan(t *testing.T) {
+	server := newFakeServer(t)
+	server.AddKey("key-a", []string{"orders/created"}, []string{"payments/*"})
+	cli := newCLI(t, server)
+
+	var decision permission.Decision
+	cli.RunJSON(&decision, "access-key", "can", "key-a", "send", "orders/created")
+	assert.True(t, decision.Allowed)
+	assert.Equal(t, "orders/created", decision.Entry)
+	assert.False(t, decision.Pattern)
+
+	cli.RunJSON(&decision, "access-key", "can", "key-a", "receive", "payments/settled")
+	assert.True(t, decision.Allowed)
+	assert.Equal(t, "payments/*", decision.Entry)
+	assert.True(t, decision.Pattern)
+
+	stdout, _, err := cli.Run("", "access-key", "can", "key-a", "receive", "orders/created")
+	require.Error(t, err)
+	require.NoError(t, json.Unmarshal([]byte(stdout), &decision))
+	assert.False(t, decision.Allowed)
+}
+
+func TestAccessKeyCanWithoutPermissions(t *testing.T) {
+	bodies := map[string]string{
+		"null response":       `null`,
+		"missing permissions": `{"accessKey": "key-a"}`,
+		"null permissions":    `{"accessKey": "key-a", "permissions": null}`,
+	}
+
+	for name, body := range bodies {
+		t.Run(name, func(t *testing.T) {
+			server := newFakeServer(t)
+			server.Intercept(func(w http.ResponseWriter, r *http.Request) bool {
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(body))
+				return true
+			})
+			cli := newCLI(t, server)
+
+			stdout, stderr, err := cli.Run("", "access-key", "can", "key-a", "send", "orders/created")
+			require.Error(t, err)
+			assert.NotContains(t, stderr, "panic")
+			assert.Contains(t, stderr, "access key key-a cannot send orders/created")
+
+			var decision permission.Decision
+			require.NoError(t, json.Unmarshal([]byte(stdout), &decision))
+			assert.False(t, decision.Allowed)
+		})
+	}
+}
+
+func TestAccessKeyCanWithMixedCaseRole(t *testing.T) {
+	server := newFakeServer(t)
+	server.AddEvents("payments/settled")
+	cli := newCLI(t, server)
+	// The assignments file keeps the name as typed, while the config loader
+	// lower-cases it
+	writeRoles(t, cli, `
+roles:
+  Payments-Reader:
+    receive: [payments/settled]
+`)
+	cli.MustRun("access-key", "create", "--role", "Payments-Reader")
+
+	var decision permission.Decision
+	cli.RunJSON(&decision, "access-key", "can", "key-1", "receive", "payments/settled")
+	assert.True(t, decision.Allowed)
+	assert.Equal(t, []string{"Payments-Reader"}, decision.Roles)
+}
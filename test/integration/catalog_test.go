@@ -0,0 +1,102 @@
+package integration
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rossi1/ensync-cli/internal/catalog"
+)
+
+func TestCatalogDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"", "abc", 3},
+		{"abc", "", 3},
+		{"order", "order", 0},
+		{"kitten", "sitting", 3},
+		{"flaw", "lawn", 2},
+		{"orders/created", "orders/creatd", 1},
+		{"orders/paid", "orders/pad", 1},
+		{"héllo", "hello", 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.a+"->"+tt.b, func(t *testing.T) {
+			assert.Equal(t, tt.want, catalog.Distance(tt.a, tt.b))
+			assert.Equal(t, tt.want, catalog.Distance(tt.b, tt.a))
+		})
+	}
+}
+
+func TestCatalogUnknownString(t *testing.T) {
+	assert.Equal(t, `unknown event "x"`, catalog.Unknown{Name: "x"}.String())
+	assert.Equal(t, `unknown event "x", did you mean "y" or "z"?`,
+		catalog.Unknown{Name: "x", Suggestions: []string{"y", "z"}}.String())
+}
+
+func catalogServer(t *testing.T) *fakeServer {
+	server := newFakeServer(t)
+	server.AddEvents("orders/created", "orders/creates", "orders/paid", "payments/settled", "users/created")
+	return server
+}
+
+func TestCreateAccessKeyRefusesUnknownEvents(t *testing.T) {
+	server := catalogServer(t)
+	cli := newCLI(t, server)
+
+	_, stderr, err := cli.Run("", "access-key", "create",
+		"--permissions", `{"send": ["orders/creatd", "orders/paid"], "receive": ["payments/*", "billing/charged"]}`)
+	require.Error(t, err)
+
+	// Suggestions are sorted by distance, then by name
+	assert.Contains(t, stderr, `Warning: unknown event "orders/creatd", did you mean "orders/created" or "orders/creates"?`)
+	assert.Contains(t, stderr, `Warning: unknown event "billing/charged"`+"\n")
+	assert.Less(t, strings.Index(stderr, "orders/creatd"), strings.Index(stderr, "billing/charged"))
+	assert.Contains(t, stderr, "2 permission(s) reference unknown events, pass --allow-unknown")
+	assert.Empty(t, server.Keys())
+
+	// Prefix patterns are never looked up
+	assert.Zero(t, server.CountRequests("GET", "/event/payments/*"))
+}
+
+func TestCreateAccessKeyAllowUnknownEvents(t *testing.T) {
+	server := catalogServer(t)
+	cli := newCLI(t, server)
+
+	var key createdKey
+	_, stderr, err := cli.Run("", "access-key", "create", "--allow-unknown",
+		"--permissions", `{"send": ["orders/creatd"], "receive": []}`)
+	require.NoError(t, err, stderr)
+	assert.Contains(t, stderr, `Warning: unknown event "orders/creatd"`)
+
+	require.Len(t, server.Keys(), 1)
+	assert.Equal(t, []string{"orders/creatd"}, server.Permissions(server.Keys()[0]).Send)
+
+	cli.RunJSON(&key, "access-key", "create", "--permissions", `{"send": ["orders/created"], "receive": ["users/created"]}`)
+	assert.Equal(t, []string{"orders/created"}, key.Permissions.Send)
+}
+
+func TestCreateAccessKeyStopsOnLookupError(t *testing.T) {
+	server := catalogServer(t)
+	server.Intercept(func(w http.ResponseWriter, r *http.Request) bool {
+		if r.URL.Path != "/event/orders/paid" {
+			return false
+		}
+		writeFakeError(w, http.StatusBadRequest, "lookup failed")
+		return true
+	})
+	cli := newCLI(t, server)
+
+	_, stderr, err := cli.Run("", "access-key", "create",
+		"--permissions", `{"send": ["orders/created", "orders/paid"], "receive": []}`)
+	require.Error(t, err)
+	assert.Contains(t, stderr, "failed to validate permissions")
+	assert.Empty(t, server.Keys())
+}
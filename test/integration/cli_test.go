@@ -0,0 +1,324 @@
+package integration
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rossi1/ensync-cli/cmd"
+	"github.com/rossi1/ensync-cli/internal/domain"
+)
+
+// cliEnv makes the test binary run the CLI instead of the tests, so that
+// every command runs in a process of its own like it does for users
+const cliEnv = "ENSYNC_TEST_CLI"
+
+const testAPIKey = "test-api-key"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(cliEnv) == "1" {
+		if err := cmd.Execute(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// cli runs ensync commands against a fake server, with a config dir and a
+// working directory of their own
+type cli struct {
+	t       *testing.T
+	Dir     string
+	WorkDir string
+	env     []string
+}
+
+func newCLI(t *testing.T, server *fakeServer) *cli {
+	c := &cli{t: t, Dir: t.TempDir(), WorkDir: t.TempDir()}
+
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, "ENSYNC_") && !strings.HasPrefix(kv, "HOME=") {
+			c.env = append(c.env, kv)
+		}
+	}
+	c.env = append(c.env,
+		cliEnv+"=1",
+		"HOME="+c.Dir,
+		"ENSYNC_CONFIG_DIR="+c.Dir,
+		"ENSYNC_RETRY_MIN_WAIT=1ms",
+		"ENSYNC_RETRY_MAX_WAIT=5ms",
+	)
+	if server != nil {
+		c.env = append(c.env, "ENSYNC_BASE_URL="+server.URL, "ENSYNC_API_KEY="+testAPIKey)
+	}
+	return c
+}
+
+// Setenv sets an environment variable for the commands run after
+func (c *cli) Setenv(key, value string) {
+	c.env = append(c.env, key+"="+value)
+}
+
+// Run runs a command with stdin as its input
+func (c *cli) Run(stdin string, args ...string) (string, string, error) {
+	command := exec.Command(os.Args[0], args...)
+	command.Env = c.env
+	command.Dir = c.WorkDir
+	command.Stdin = strings.NewReader(stdin)
+
+	var stdout, stderr bytes.Buffer
+	command.Stdout = &stdout
+	command.Stderr = &stderr
+
+	err := command.Run()
+	return stdout.String(), stderr.String(), err
+}
+
+// MustRun runs a command that is expected to succeed and returns its output
+func (c *cli) MustRun(args ...string) string {
+	c.t.Helper()
+	stdout, stderr, err := c.Run("", args...)
+	require.NoError(c.t, err, "ensync %s\n%s", strings.Join(args, " "), stderr)
+	return stdout
+}
+
+// RunJSON runs a command that is expected to succeed and decodes its output
+func (c *cli) RunJSON(v interface{}, args ...string) {
+	c.t.Helper()
+	require.NoError(c.t, json.Unmarshal([]byte(c.MustRun(args...)), v))
+}
+
+// fakeServer is an in-memory EnSync API
+type fakeServer struct {
+	*httptest.Server
+
+	mu          sync.Mutex
+	events      map[string]*domain.Event
+	keys        []string
+	permissions map[string]*domain.Permissions
+	metadata    map[string]*domain.AccessKeyMetadata
+	requests    []string
+	intercept   func(w http.ResponseWriter, r *http.Request) bool
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	s := &fakeServer{
+		events:      map[string]*domain.Event{},
+		permissions: map[string]*domain.Permissions{},
+		metadata:    map[string]*domain.AccessKeyMetadata{},
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// Intercept makes fn answer the requests for which it returns true instead
+// of the fake
+func (s *fakeServer) Intercept(fn func(w http.ResponseWriter, r *http.Request) bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.intercept = fn
+}
+
+// AddEvents creates events with the given names
+func (s *fakeServer) AddEvents(names ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, name := range names {
+		s.events[name] = &domain.Event{ID: int64(len(s.events) + 1), Name: name}
+	}
+}
+
+// AddKey creates an access key with permissions
+func (s *fakeServer) AddKey(key string, send, receive []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = append(s.keys, key)
+	s.permissions[key] = &domain.Permissions{Send: send, Receive: receive}
+}
+
+// Permissions returns the permissions of a key, nil when it does not exist
+func (s *fakeServer) Permissions(key string) *domain.Permissions {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.permissions[key]
+}
+
+// Keys returns the access keys in creation order
+func (s *fakeServer) Keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.keys...)
+}
+
+// Requests returns the method and path of every request served
+func (s *fakeServer) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+// CountRequests returns the number of requests with the method and path
+func (s *fakeServer) CountRequests(method, path string) int {
+	count := 0
+	for _, request := range s.Requests() {
+		if request == method+" "+path {
+			count++
+		}
+	}
+	return count
+}
+
+func (s *fakeServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+	intercept := s.intercept
+	s.mu.Unlock()
+
+	if intercept != nil && intercept(w, r) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r.Header.Get("X-API-KEY") != testAPIKey {
+		writeFakeError(w, http.StatusUnauthorized, "invalid API key")
+		return
+	}
+
+	path := strings.TrimSuffix(r.URL.Path, "/")
+	switch {
+	case path == "/event" && r.Method == http.MethodGet:
+		names := make([]string, 0, len(s.events))
+		for name := range s.events {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		results := make([]*domain.Event, 0, len(names))
+		for _, name := range page(r, names) {
+			results = append(results, s.events[name])
+		}
+		sendJSONResponse(w, &domain.EventList{ResultsLength: len(names), Results: results})
+
+	case path == "/event" && r.Method == http.MethodPost:
+		var event domain.Event
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			writeFakeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		event.ID = int64(len(s.events) + 1)
+		s.events[event.Name] = &event
+		sendJSONResponse(w, map[string]interface{}{})
+
+	case strings.HasPrefix(path, "/event/") && r.Method == http.MethodGet:
+		event, ok := s.events[strings.TrimPrefix(path, "/event/")]
+		if !ok {
+			writeFakeError(w, http.StatusNotFound, "event not found")
+			return
+		}
+		sendJSONResponse(w, event)
+
+	case path == "/access-key" && r.Method == http.MethodGet:
+		keys := s.keys
+		if filter := r.URL.Query().Get("accessKey"); filter != "" {
+			keys = nil
+			for _, key := range s.keys {
+				if key == filter {
+					keys = append(keys, key)
+				}
+			}
+		}
+		results := []*domain.AccessKeyPermissions{}
+		for _, key := range page(r, keys) {
+			results = append(results, &domain.AccessKeyPermissions{Key: key, Permissions: s.permissions[key]})
+		}
+		sendJSONResponse(w, &domain.AccessKeyList{ResultsLength: len(keys), Results: results})
+
+	case path == "/access-key" && r.Method == http.MethodPost:
+		var body struct {
+			Permissions *domain.Permissions `json:"permissions"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeFakeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		key := fmt.Sprintf("key-%d", len(s.keys)+1)
+		s.keys = append(s.keys, key)
+		s.permissions[key] = body.Permissions
+		sendJSONResponse(w, &domain.AccessKey{AccessKey: key})
+
+	case strings.HasPrefix(path, "/access-key/permissions/"):
+		key := strings.TrimPrefix(path, "/access-key/permissions/")
+		permissions, ok := s.permissions[key]
+		if !ok {
+			writeFakeError(w, http.StatusNotFound, "access key not found")
+			return
+		}
+		if r.Method == http.MethodGet {
+			sendJSONResponse(w, &domain.AccessKeyPermissions{Key: key, Permissions: permissions})
+			return
+		}
+		var updated domain.Permissions
+		if err := json.NewDecoder(r.Body).Decode(&updated); err != nil {
+			writeFakeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		s.permissions[key] = &updated
+		sendJSONResponse(w, map[string]interface{}{})
+
+	case path == "/access-key/metadata" && r.Method == http.MethodGet:
+		sendJSONResponse(w, map[string]interface{}{"results": s.metadata})
+
+	case strings.HasPrefix(path, "/access-key/metadata/"):
+		key := strings.TrimPrefix(path, "/access-key/metadata/")
+		if r.Method == http.MethodDelete {
+			delete(s.metadata, key)
+			sendJSONResponse(w, map[string]interface{}{})
+			return
+		}
+		var metadata domain.AccessKeyMetadata
+		if err := json.NewDecoder(r.Body).Decode(&metadata); err != nil {
+			writeFakeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		s.metadata[key] = &metadata
+		sendJSONResponse(w, map[string]interface{}{})
+
+	default:
+		writeFakeError(w, http.StatusNotFound, "no route for "+r.Method+" "+path)
+	}
+}
+
+// page returns the items of the page selected by the pageIndex and limit
+// query parameters
+func page(r *http.Request, items []string) []string {
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = len(items)
+	}
+	index, _ := strconv.Atoi(r.URL.Query().Get("pageIndex"))
+
+	start := min(index*limit, len(items))
+	end := min(start+limit, len(items))
+	return items[start:end]
+}
+
+func writeFakeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"message": message})
+}
@@ -0,0 +1,106 @@
+package integration
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func cloneServer(t *testing.T) *fakeServer {
+	server := newFakeServer(t)
+	server.AddEvents("orders/created", "orders/paid", "payments/settled", "users/created")
+	server.AddKey("key-a", []string{"orders/created", "orders/paid"}, []string{"payments/*"})
+	return server
+}
+
+func TestAccessKeyClone(t *testing.T) {
+	server := cloneServer(t)
+	cli := newCLI(t, server)
+
+	var key createdKey
+	cli.RunJSON(&key, "access-key", "clone", "--from", "key-a")
+	require.NotEmpty(t, key.AccessKey)
+	assert.Equal(t, []string{"orders/created", "orders/paid"}, key.Permissions.Send)
+	assert.Equal(t, []string{"payments/*"}, key.Permissions.Receive)
+	assert.Equal(t, key.Permissions, server.Permissions(key.AccessKey))
+
+	// The source key is left alone
+	assert.Equal(t, []string{"orders/created", "orders/paid"}, server.Permissions("key-a").Send)
+}
+
+func TestAccessKeyCloneWithChanges(t *testing.T) {
+	server := cloneServer(t)
+	cli := newCLI(t, server)
+
+	var key createdKey
+	cli.RunJSON(&key, "access-key", "clone", "--from", "key-a",
+		"--add-send", "users/created,orders/created",
+		"--drop-send", "orders/paid",
+		"--add-receive", "orders/paid",
+		"--drop-receive", "payments/*")
+
+	assert.Equal(t, []string{"orders/created", "users/created"}, server.Permissions(key.AccessKey).Send)
+	assert.Equal(t, []string{"orders/paid"}, server.Permissions(key.AccessKey).Receive)
+}
+
+func TestAccessKeyCloneErrors(t *testing.T) {
+	server := cloneServer(t)
+	cli := newCLI(t, server)
+
+	_, stderr, err := cli.Run("", "access-key", "clone")
+	require.Error(t, err)
+	assert.Contains(t, stderr, `required flag(s) "from" not set`)
+
+	_, stderr, err = cli.Run("", "access-key", "clone", "--from", "missing")
+	require.Error(t, err)
+	assert.Contains(t, stderr, "failed to get permissions")
+
+	_, stderr, err = cli.Run("", "access-key", "clone", "--from", "key-a", "--add-send", "orders/creatd")
+	require.Error(t, err)
+	assert.Contains(t, stderr, `unknown event "orders/creatd"`)
+
+	assert.Equal(t, []string{"key-a"}, server.Keys())
+}
+
+type keyComparison struct {
+	First   string      `json:"first"`
+	Second  string      `json:"second"`
+	Equal   bool        `json:"equal"`
+	Send    entriesDiff `json:"send"`
+	Receive entriesDiff `json:"receive"`
+}
+
+type entriesDiff struct {
+	OnlyFirst  []string `json:"onlyFirst"`
+	OnlySecond []string `json:"onlySecond"`
+	Both       []string `json:"both"`
+}
+
+func TestAccessKeyCompare(t *testing.T) {
+	server := newFakeServer(t)
+	server.AddKey("key-a", []string{"orders/created", "orders/paid", "orders/created"}, []string{"payments/*"})
+	server.AddKey("key-b", []string{"orders/paid", "users/created"}, []string{"payments/*"})
+	server.AddKey("key-c", []string{"users/created", "orders/paid"}, []string{"payments/*"})
+	cli := newCLI(t, server)
+
+	var comparison keyComparison
+	cli.RunJSON(&comparison, "access-key", "compare", "key-a", "key-b")
+	assert.Equal(t, "key-a", comparison.First)
+	assert.Equal(t, "key-b", comparison.Second)
+	assert.False(t, comparison.Equal)
+	assert.Equal(t, entriesDiff{
+		OnlyFirst:  []string{"orders/created"},
+		OnlySecond: []string{"users/created"},
+		Both:       []string{"orders/paid"},
+	}, comparison.Send)
+	assert.Equal(t, entriesDiff{OnlyFirst: []string{}, OnlySecond: []string{}, Both: []string{"payments/*"}}, comparison.Receive)
+
+	// The order of the entries does not matter
+	cli.RunJSON(&comparison, "access-key", "compare", "key-b", "key-c")
+	assert.True(t, comparison.Equal)
+
+	_, stderr, err := cli.Run("", "access-key", "compare", "key-a", "missing")
+	require.Error(t, err)
+	assert.Contains(t, stderr, "failed to get permissions for missing")
+}
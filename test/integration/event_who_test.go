@@ -0,0 +1,87 @@
+package integration
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type eventWho struct {
+	Event string `json:"event"`
+	Send  []struct {
+		Key     string `json:"key"`
+		Entry   string `json:"entry"`
+		Pattern bool   `json:"pattern"`
+	} `json:"send"`
+	Receive []struct {
+		Key     string `json:"key"`
+		Entry   string `json:"entry"`
+		Pattern bool   `json:"pattern"`
+	} `json:"receive"`
+}
+
+func TestEventWho(t *testing.T) {
+	server := newFakeServer(t)
+	server.AddKey("key-1", []string{"orders/created"}, []string{})
+	server.AddKey("key-2", []string{"orders/*"}, []string{"orders/created"})
+	server.AddKey("key-3", []string{"payments/*"}, []string{"orders/*"})
+	server.AddKey("key-4", []string{}, []string{})
+	cli := newCLI(t, server)
+
+	var who eventWho
+	cli.RunJSON(&who, "event", "who", "--name", "orders/created")
+
+	assert.Equal(t, "orders/created", who.Event)
+	require.Len(t, who.Send, 2)
+	assert.Equal(t, "key-1", who.Send[0].Key)
+	assert.False(t, who.Send[0].Pattern)
+	assert.Equal(t, "key-2", who.Send[1].Key)
+	assert.Equal(t, "orders/*", who.Send[1].Entry)
+	assert.True(t, who.Send[1].Pattern)
+
+	require.Len(t, who.Receive, 2)
+	assert.Equal(t, "key-2", who.Receive[0].Key)
+	assert.Equal(t, "key-3", who.Receive[1].Key)
+}
+
+func TestEventWhoNamespace(t *testing.T) {
+	server := newFakeServer(t)
+	server.AddKey("key-1", []string{"shop/orders/created"}, []string{})
+	cli := newCLI(t, server)
+
+	var who eventWho
+	cli.RunJSON(&who, "--namespace", "shop/", "event", "who", "--name", "orders/created")
+	assert.Equal(t, "shop/orders/created", who.Event)
+	assert.Len(t, who.Send, 1)
+}
+
+func TestEventWhoStopsAtFirstError(t *testing.T) {
+	server := newFakeServer(t)
+	for i := 1; i <= 50; i++ {
+		server.AddKey(fmt.Sprintf("key-%d", i), []string{"orders/created"}, []string{})
+	}
+	server.Intercept(func(w http.ResponseWriter, r *http.Request) bool {
+		if r.URL.Path != "/access-key/permissions/key-2" {
+			return false
+		}
+		writeFakeError(w, http.StatusForbidden, "forbidden")
+		return true
+	})
+	cli := newCLI(t, server)
+
+	_, stderr, err := cli.Run("", "event", "who", "--name", "orders/created", "--concurrency", "1")
+	require.Error(t, err)
+	assert.Contains(t, stderr, "failed to get permissions for key-2")
+
+	fetched := 0
+	for _, request := range server.Requests() {
+		if strings.HasPrefix(request, "GET /access-key/permissions/") {
+			fetched++
+		}
+	}
+	assert.LessOrEqual(t, fetched, 3)
+}
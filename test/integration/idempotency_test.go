@@ -0,0 +1,216 @@
+package integration
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rossi1/ensync-cli/internal/api"
+	"github.com/rossi1/ensync-cli/internal/domain"
+)
+
+// recordKeys returns a server failing the first request with 503 and
+// recording the Idempotency-Key of every request
+func recordKeys(t *testing.T) (*httptest.Server, func() []string) {
+	var mu sync.Mutex
+	var keys []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		keys = append(keys, r.Header.Get(api.IdempotencyKeyHeader))
+		first := len(keys) == 1
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		if first {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"message": "try again later"}`))
+			return
+		}
+		w.Write([]byte(`{"resultsLength": 0, "results": []}`))
+	}))
+	t.Cleanup(server.Close)
+
+	return server, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), keys...)
+	}
+}
+
+func TestIdempotencyKeyStableAcrossRetries(t *testing.T) {
+	server, keys := recordKeys(t)
+	client := api.NewClient(server.URL, "key", api.WithRetryPolicy(fastRetries))
+
+	ctx := api.ForceRetry(context.Background())
+	require.NoError(t, client.CreateEvent(ctx, &domain.Event{Name: "orders/created"}))
+
+	sent := keys()
+	require.Len(t, sent, 2)
+	assert.Regexp(t, `^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`, sent[0])
+	assert.Equal(t, sent[0], sent[1])
+
+	// Every call gets a key of its own
+	require.NoError(t, client.CreateEvent(ctx, &domain.Event{Name: "orders/shipped"}))
+	sent = keys()
+	require.Len(t, sent, 3)
+	assert.NotEqual(t, sent[0], sent[2])
+}
+
+func TestIdempotencyKeyPinned(t *testing.T) {
+	server, keys := recordKeys(t)
+	client := api.NewClient(server.URL, "key", api.WithRetryPolicy(fastRetries))
+
+	ctx := api.UseIdempotencyKey(context.Background(), "deploy-42")
+	require.Error(t, client.CreateEvent(ctx, &domain.Event{Name: "orders/created"}))
+	require.NoError(t, client.CreateEvent(ctx, &domain.Event{Name: "orders/created"}))
+	assert.Equal(t, []string{"deploy-42", "deploy-42"}, keys())
+
+	// Reads carry no key
+	require.NoError(t, listEvents(client))
+	assert.Equal(t, "", keys()[2])
+}
+
+func TestIsTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	client := api.NewClient(server.URL, "key", api.WithTimeout(50*time.Millisecond))
+	err := client.CreateEvent(context.Background(), &domain.Event{Name: "orders/created"})
+	require.Error(t, err)
+	assert.True(t, api.IsTimeout(err))
+
+	assert.False(t, api.IsTimeout(&api.APIError{StatusCode: http.StatusGatewayTimeout}))
+}
+
+// slowCreates makes the fake server answer the first access key create after
+// the client gave up, adding the created keys first. It returns the
+// Idempotency-Key of every create.
+func slowCreates(server *fakeServer, created ...string) func() []string {
+	var mu sync.Mutex
+	var keys []string
+	server.Intercept(func(w http.ResponseWriter, r *http.Request) bool {
+		if r.Method != http.MethodPost || r.URL.Path != "/access-key" {
+			return false
+		}
+
+		mu.Lock()
+		keys = append(keys, r.Header.Get(api.IdempotencyKeyHeader))
+		first := len(keys) == 1
+		mu.Unlock()
+		if !first {
+			return false
+		}
+
+		for _, key := range created {
+			server.AddKey(key, []string{"orders/created"}, []string{})
+		}
+		time.Sleep(500 * time.Millisecond)
+		return true
+	})
+
+	return func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), keys...)
+	}
+}
+
+func timeoutCLI(t *testing.T, server *fakeServer) *cli {
+	cli := newCLI(t, server)
+	cli.Setenv("ENSYNC_TIMEOUT", "100ms")
+	return cli
+}
+
+func TestCreateAccessKeyListsKeysOnce(t *testing.T) {
+	server := createServer(t)
+	cli := newCLI(t, server)
+
+	cli.MustRun("access-key", "create", "--count", "3", "--permissions", `{"send": ["orders/created"], "receive": []}`)
+	assert.Equal(t, 3, server.CountRequests("POST", "/access-key"))
+	assert.Equal(t, 1, server.CountRequests("GET", "/access-key"))
+}
+
+func TestCreateAccessKeyRecoversCreatedKey(t *testing.T) {
+	server := createServer(t)
+	creates := slowCreates(server, "key-slow")
+	cli := timeoutCLI(t, server)
+
+	var key createdKey
+	stdout, stderr, err := cli.Run("", "access-key", "create", "--permissions", `{"send": ["orders/created"], "receive": []}`)
+	require.NoError(t, err, stderr)
+	require.NoError(t, json.Unmarshal([]byte(stdout), &key))
+
+	assert.Equal(t, "key-slow", key.AccessKey)
+	assert.Contains(t, stderr, "Access key key-slow is new and has the requested permissions")
+	assert.Len(t, creates(), 1)
+	assert.Equal(t, []string{"key-slow"}, server.Keys())
+}
+
+func TestCreateAccessKeyRetriesAfterTimeout(t *testing.T) {
+	server := createServer(t)
+	creates := slowCreates(server)
+	cli := timeoutCLI(t, server)
+
+	stdout, stderr, err := cli.Run("", "access-key", "create", "--permissions", `{"send": ["orders/created"], "receive": []}`)
+	require.NoError(t, err, stderr)
+	assert.Contains(t, stderr, "Access key was not created, retrying with Idempotency-Key")
+
+	sent := creates()
+	require.Len(t, sent, 2)
+	assert.NotEmpty(t, sent[0])
+	assert.Equal(t, sent[0], sent[1])
+	assert.Contains(t, stdout, server.Keys()[0])
+}
+
+func TestCreateAccessKeyIgnoresExistingKeysAfterTimeout(t *testing.T) {
+	server := createServer(t)
+	// A key with the same permissions that existed before the command ran
+	server.AddKey("key-old", []string{"orders/created"}, []string{})
+	creates := slowCreates(server)
+	cli := timeoutCLI(t, server)
+
+	stdout, stderr, err := cli.Run("", "access-key", "create", "--permissions", `{"send": ["orders/created"], "receive": []}`)
+	require.NoError(t, err, stderr)
+	assert.Contains(t, stderr, "Access key was not created, retrying with Idempotency-Key")
+	assert.NotContains(t, stdout, "key-old")
+
+	var key createdKey
+	require.NoError(t, json.Unmarshal([]byte(stdout), &key))
+	assert.NotEqual(t, "key-old", key.AccessKey)
+	assert.Len(t, creates(), 2)
+	assert.Len(t, server.Keys(), 2)
+}
+
+func TestCreateAccessKeyRefusesToGuessAfterTimeout(t *testing.T) {
+	server := createServer(t)
+	server.AddKey("key-old", []string{"orders/created"}, []string{})
+	// Another client creates a key with the same permissions in the meantime
+	creates := slowCreates(server, "key-other", "key-slow")
+	cli := timeoutCLI(t, server)
+
+	stdout, stderr, err := cli.Run("", "access-key", "create", "--permissions", `{"send": ["orders/created"], "receive": []}`)
+	require.Error(t, err)
+	assert.Empty(t, stdout)
+	assert.Contains(t, stderr, "2 new keys have the requested permissions (key-other, key-slow)")
+	assert.Len(t, creates(), 1)
+}
+
+func TestCloneAccessKeyPinnedIdempotencyKey(t *testing.T) {
+	server := cloneServer(t)
+	creates := slowCreates(server)
+	cli := timeoutCLI(t, server)
+
+	_, stderr, err := cli.Run("", "access-key", "clone", "--from", "key-a", "--idempotency-key", "clone-7")
+	require.NoError(t, err, stderr)
+	assert.Contains(t, stderr, "Access key was not created, retrying with Idempotency-Key clone-7")
+	assert.Equal(t, []string{"clone-7", "clone-7"}, creates())
+}
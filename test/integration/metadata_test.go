@@ -0,0 +1,209 @@
+package integration
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rossi1/ensync-cli/internal/domain"
+	"github.com/rossi1/ensync-cli/internal/label"
+	"github.com/rossi1/ensync-cli/internal/metadata"
+)
+
+func TestLabelSelector(t *testing.T) {
+	labels := map[string]string{"team": "payments", "env": "prod", "external": ""}
+
+	tests := []struct {
+		selector string
+		matches  bool
+	}{
+		{"", true},
+		{"team=payments", true},
+		{"team=orders", false},
+		{"team=payments,env!=prod", false},
+		{"team=payments, env!=staging", true},
+		{"external", true},
+		{"internal", false},
+		{"owner!=bob", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.selector, func(t *testing.T) {
+			sel, err := label.ParseSelector(tt.selector)
+			require.NoError(t, err)
+			assert.Equal(t, tt.matches, sel.Matches(labels))
+		})
+	}
+
+	_, err := label.ParseSelector("team=payments,=prod")
+	assert.ErrorContains(t, err, "missing label name")
+}
+
+func TestLabelParseAndFormat(t *testing.T) {
+	labels, err := label.Parse("team=payments, external")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"team": "payments", "external": ""}, labels)
+	assert.Equal(t, "external,team=payments", label.Format(labels))
+
+	merged := label.Merge(map[string]string{"team": "orders", "env": "prod"}, labels)
+	assert.Equal(t, "env=prod,external,team=payments", label.Format(merged))
+
+	_, err = label.Parse("=payments")
+	assert.Error(t, err)
+}
+
+func TestMetadataFileStore(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "access-keys.yaml")
+
+	// A file left world-readable by an older version is replaced
+	require.NoError(t, os.WriteFile(path, []byte("key-0:\n  owner: ops\n"), 0o644))
+
+	store := metadata.NewFileStore(path)
+	ctx := context.Background()
+	require.NoError(t, store.Save(ctx, "key-1", &domain.AccessKeyMetadata{Owner: "payments", Labels: map[string]string{"team": "payments"}}))
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	all, err := store.Load(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "ops", all["key-0"].Owner)
+	assert.Equal(t, "payments", all["key-1"].Labels["team"])
+
+	require.NoError(t, store.Delete(ctx, "key-0"))
+	all, err = store.Load(ctx)
+	require.NoError(t, err)
+	assert.NotContains(t, all, "key-0")
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1, "temporary files are removed")
+}
+
+func TestMetadataFileStoreConcurrentWriters(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "access-keys.yaml")
+	ctx := context.Background()
+
+	// Stores of their own stand for separate processes
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- metadata.NewFileStore(path).Save(ctx, fmt.Sprintf("key-%d", i), &domain.AccessKeyMetadata{Owner: "team"})
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+
+	all, err := metadata.NewFileStore(path).Load(ctx)
+	require.NoError(t, err)
+	assert.NotEmpty(t, all)
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1, "temporary files are removed")
+}
+
+func TestMetadataCommands(t *testing.T) {
+	server := newFakeServer(t)
+	server.AddKey("key-1", []string{"orders/created"}, []string{})
+	server.AddKey("key-2", []string{}, []string{"orders/created"})
+	server.AddKey("key-3", []string{}, []string{})
+	cli := newCLI(t, server)
+
+	soon := time.Now().Add(48 * time.Hour).UTC().Format(time.DateOnly)
+	cli.MustRun("access-key", "metadata", "set", "--key", "key-1", "--label", "team=payments", "--label", "env=prod", "--owner", "payments-team", "--expires", soon)
+	cli.MustRun("access-key", "metadata", "set", "--key", "key-2", "--label", "team=payments", "--description", "consumer")
+	cli.MustRun("access-key", "metadata", "set", "--key", "key-1", "--label", "env-")
+
+	var md domain.AccessKeyMetadata
+	cli.RunJSON(&md, "access-key", "metadata", "get", "--key", "key-1")
+	assert.Equal(t, map[string]string{"team": "payments"}, md.Labels)
+	assert.Equal(t, "payments-team", md.Owner)
+	require.NotNil(t, md.ExpiresAt)
+
+	info, err := os.Stat(filepath.Join(cli.Dir, metadata.DefaultFile))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	keys := func(args ...string) []string {
+		var list domain.AccessKeyList
+		cli.RunJSON(&list, append([]string{"access-key", "list"}, args...)...)
+		var names []string
+		for _, key := range list.Results {
+			names = append(names, key.Key)
+		}
+		return names
+	}
+	assert.Equal(t, []string{"key-1", "key-2"}, keys("--selector", "team=payments"))
+	assert.Equal(t, []string{"key-3"}, keys("--selector", "team!=payments"))
+	assert.Equal(t, []string{"key-1"}, keys("--expiring-within", "7d"))
+	assert.Empty(t, keys("--expiring-within", "1h"))
+
+	cli.MustRun("access-key", "metadata", "delete", "--key", "key-1")
+	_, stderr, err := cli.Run("", "access-key", "metadata", "get", "--key", "key-1")
+	require.Error(t, err)
+	assert.Contains(t, stderr, "no metadata for access key key-1")
+}
+
+func TestMetadataServerStore(t *testing.T) {
+	server := newFakeServer(t)
+	server.AddKey("key-1", []string{}, []string{})
+	cli := newCLI(t, server)
+	cli.Setenv("ENSYNC_METADATA_STORE", "server")
+
+	cli.MustRun("access-key", "metadata", "set", "--key", "key-1", "--owner", "ops")
+	assert.Equal(t, 1, server.CountRequests("PUT", "/access-key/metadata/key-1"))
+	assert.NoFileExists(t, filepath.Join(cli.Dir, metadata.DefaultFile))
+
+	var md domain.AccessKeyMetadata
+	cli.RunJSON(&md, "access-key", "metadata", "get", "--key", "key-1")
+	assert.Equal(t, "ops", md.Owner)
+}
+
+func TestLabelsFile(t *testing.T) {
+	server := newFakeServer(t)
+	server.AddEvents("orders/created")
+	server.AddKey("key-1", []string{"orders/created"}, []string{})
+	server.AddKey("key-2", []string{"orders/created"}, []string{})
+	cli := newCLI(t, server)
+	cli.MustRun("access-key", "metadata", "set", "--key", "key-1", "--label", "team=orders")
+
+	labels := filepath.Join(cli.WorkDir, "labels.yaml")
+	require.NoError(t, os.WriteFile(labels, []byte("key-1: external\nkey-2: team=payments,external\n"), 0o600))
+
+	out := cli.MustRun("report", "matrix", "--labels", labels)
+	assert.Contains(t, out, "key-1,\"external,team=orders\",S\n")
+	assert.Contains(t, out, "key-2,\"external,team=payments\",S\n")
+
+	out = cli.MustRun("report", "matrix", "--labels", labels, "--selector", "team=payments")
+	assert.NotContains(t, out, "key-1")
+
+	policy := filepath.Join(cli.WorkDir, "policy.yaml")
+	require.NoError(t, os.WriteFile(policy, []byte(`
+rules:
+  - id: EXT-001
+    type: receive-only
+    label: external
+`), 0o600))
+
+	cli.MustRun("policy", "check", "-f", policy)
+	stdout, _, err := cli.Run("", "policy", "check", "-f", policy, "--labels", labels)
+	require.Error(t, err)
+	assert.Contains(t, stdout, `"key": "key-1"`)
+	assert.Contains(t, stdout, `"key": "key-2"`)
+}
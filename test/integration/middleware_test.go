@@ -0,0 +1,94 @@
+package integration
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+
+	"github.com/rossi1/ensync-cli/internal/api"
+)
+
+func TestMiddlewareChain(t *testing.T) {
+	var mu sync.Mutex
+	var requestIDs []string
+	var failed bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requestIDs = append(requestIDs, r.Header.Get(api.RequestIDHeader))
+		assert.Equal(t, "ensync-test", r.Header.Get("User-Agent"))
+
+		// Fail the first attempt so that the request is retried
+		if !failed {
+			failed = true
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"resultsLength": 0, "results": []}`))
+	}))
+	defer server.Close()
+
+	var order []string
+	trace := func(name string) api.Middleware {
+		return func(next http.RoundTripper) http.RoundTripper {
+			return api.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				order = append(order, name)
+				return next.RoundTrip(req)
+			})
+		}
+	}
+
+	var timings []time.Duration
+	timing := api.NewTimingMiddleware(func(req *http.Request, resp *http.Response, err error, duration time.Duration) {
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		timings = append(timings, duration)
+	})
+
+	client := api.NewClient(server.URL, "key",
+		api.WithMiddleware(trace("outer"), api.NewRequestIDMiddleware()),
+		api.WithMiddleware(api.NewHeaderMiddleware(http.Header{"User-Agent": {"ensync-test"}}), timing, trace("inner")),
+	)
+	require.NoError(t, listEvents(client))
+
+	// Middlewares run once around the retries
+	assert.Equal(t, []string{"outer", "inner"}, order)
+	assert.Len(t, timings, 1)
+
+	require.Len(t, requestIDs, 2)
+	assert.NotEmpty(t, requestIDs[0])
+	assert.Equal(t, requestIDs[0], requestIDs[1])
+}
+
+func TestLoggingMiddlewareFailedRequest(t *testing.T) {
+	core, logs := observer.New(zap.DebugLevel)
+
+	failing := &http.Client{Transport: api.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return nil, errors.New("connection refused")
+	})}
+	client := api.NewClient("http://ensync.invalid", "key",
+		api.WithCustomHTTPClient(failing),
+		api.WithMiddleware(api.NewLoggingMiddleware(zap.New(core))),
+	)
+
+	err := listEvents(client)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "connection refused")
+
+	entries := logs.FilterMessage("API request failed").All()
+	require.Len(t, entries, 1)
+	assert.Equal(t, "GET", entries[0].ContextMap()["method"])
+
+	// The client given to WithCustomHTTPClient is left unchanged
+	_, ok := failing.Transport.(api.RoundTripperFunc)
+	assert.True(t, ok)
+}
@@ -0,0 +1,114 @@
+package integration
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/rossi1/ensync-cli/pkg/permission"
+)
+
+func TestPermissionMatch(t *testing.T) {
+	tests := []struct {
+		entry string
+		name  string
+		match bool
+	}{
+		{"orders/created", "orders/created", true},
+		{"orders/created", "orders/created/v2", false},
+		{"orders/created", "orders/paid", false},
+		{"orders/*", "orders/created", true},
+		{"orders/*", "orders/", true},
+		{"orders/*", "orders", false},
+		{"orders/*", "payments/created", false},
+		{"orders*", "orders-archive/created", true},
+		{"*", "anything", true},
+		{"", "", true},
+		{"", "orders/created", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.entry+" "+tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.match, permission.Match(tt.entry, tt.name))
+		})
+	}
+}
+
+func TestPermissionMatchAny(t *testing.T) {
+	// Exact entries win over patterns listed before them
+	entry, ok := permission.MatchAny([]string{"orders/*", "orders/created"}, "orders/created")
+	assert.True(t, ok)
+	assert.Equal(t, "orders/created", entry)
+
+	// otherwise the first matching pattern wins
+	entry, ok = permission.MatchAny([]string{"payments/*", "orders/*", "*"}, "orders/paid")
+	assert.True(t, ok)
+	assert.Equal(t, "orders/*", entry)
+
+	entry, ok = permission.MatchAny([]string{"orders/created", "payments/*"}, "orders/paid")
+	assert.False(t, ok)
+	assert.Empty(t, entry)
+
+	_, ok = permission.MatchAny(nil, "orders/paid")
+	assert.False(t, ok)
+}
+
+func TestPermissionEvaluate(t *testing.T) {
+	set := permission.Set{
+		Send:    []string{"orders/created", "payments/*"},
+		Receive: []string{"orders/*"},
+	}
+
+	tests := []struct {
+		name      string
+		direction permission.Direction
+		event     string
+		allowed   bool
+		entry     string
+		pattern   bool
+	}{
+		{"exact send", permission.Send, "orders/created", true, "orders/created", false},
+		{"pattern send", permission.Send, "payments/settled", true, "payments/*", true},
+		{"pattern receive", permission.Receive, "orders/created", true, "orders/*", true},
+		{"denied send", permission.Send, "orders/paid", false, "", false},
+		{"denied receive", permission.Receive, "payments/settled", false, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decision := permission.Evaluate(set, tt.direction, tt.event)
+			assert.Equal(t, tt.allowed, decision.Allowed)
+			assert.Equal(t, tt.entry, decision.Entry)
+			assert.Equal(t, tt.pattern, decision.Pattern)
+			assert.NotEmpty(t, decision.Reason)
+		})
+	}
+}
+
+func TestPermissionEvaluateExplainsOppositeDirection(t *testing.T) {
+	set := permission.Set{Receive: []string{"payments/*"}}
+
+	decision := permission.Evaluate(set, permission.Send, "payments/settled")
+	assert.False(t, decision.Allowed)
+	assert.Contains(t, decision.Reason, `receive entry "payments/*" only grants receive`)
+}
+
+func TestPermissionEvaluateWithRoles(t *testing.T) {
+	roles := map[string]permission.Set{
+		"order-consumer": {Receive: []string{"orders/created", "orders/paid"}},
+		"auditor":        {Receive: []string{"orders/*"}},
+	}
+	set := permission.Set{Receive: []string{"orders/created", "orders/paid", "orders/*"}}
+
+	evaluator := permission.NewEvaluator(permission.WithRoles(roles))
+
+	decision := evaluator.Evaluate(set, permission.Receive, "orders/paid")
+	assert.True(t, decision.Allowed)
+	assert.Equal(t, []string{"order-consumer"}, decision.Roles)
+
+	decision = evaluator.Evaluate(set, permission.Receive, "orders/shipped")
+	assert.True(t, decision.Allowed)
+	assert.Equal(t, "orders/*", decision.Entry)
+	assert.Equal(t, []string{"auditor"}, decision.Roles)
+	assert.Contains(t, decision.Reason, "granted by role auditor")
+}
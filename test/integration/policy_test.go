@@ -0,0 +1,154 @@
+package integration
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rossi1/ensync-cli/internal/domain"
+	"github.com/rossi1/ensync-cli/internal/policy"
+)
+
+func intPtr(n int) *int {
+	return &n
+}
+
+func TestPolicyValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		rule policy.Rule
+		err  string
+	}{
+		{"missing id", policy.Rule{Type: policy.RuleSendOnly}, "rule 1: id is required"},
+		{"unknown type", policy.Rule{ID: "R1", Type: "deny-all"}, `unknown type "deny-all"`},
+		{"missing events", policy.Rule{ID: "R1", Type: policy.RuleDenySend}, "events is required for deny-send"},
+		{"missing max", policy.Rule{ID: "R1", Type: policy.RuleMaxSend}, "max is required for max-send"},
+		{"missing max receive", policy.Rule{ID: "R1", Type: policy.RuleMaxReceive}, "max is required for max-receive"},
+		{"negative max", policy.Rule{ID: "R1", Type: policy.RuleMaxSend, Max: intPtr(-1)}, "max must not be negative"},
+		{"invalid label", policy.Rule{ID: "R1", Type: policy.RuleSendOnly, Label: "=payments"}, "missing label name"},
+		{"zero max", policy.Rule{ID: "R1", Type: policy.RuleMaxReceive, Max: intPtr(0)}, ""},
+		{"valid", policy.Rule{ID: "R1", Type: policy.RuleDenySend, Events: "payments/*", Label: "team=orders"}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &policy.Policy{Rules: []policy.Rule{tt.rule}}
+			err := p.Validate()
+			if tt.err == "" {
+				assert.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.err)
+		})
+	}
+
+	p := &policy.Policy{Rules: []policy.Rule{
+		{ID: "R1", Type: policy.RuleSendOnly},
+		{ID: "R1", Type: policy.RuleReceiveOnly},
+	}}
+	assert.ErrorContains(t, p.Validate(), "rule R1: duplicate id")
+}
+
+func TestPolicyLoadRequiresMax(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+rules:
+  - id: LIM-001
+    type: max-send
+`), 0o600))
+
+	_, err := policy.Load(path)
+	assert.ErrorContains(t, err, "max is required")
+}
+
+func TestPolicyCheck(t *testing.T) {
+	p := &policy.Policy{Rules: []policy.Rule{
+		{ID: "SOD-001", Type: policy.RuleNoSendAndReceive, Events: "payments/*"},
+		{ID: "EXT-001", Type: policy.RuleReceiveOnly, Label: "exposure=external"},
+		{ID: "LIM-001", Type: policy.RuleMaxSend, Max: intPtr(2)},
+		{ID: "LIM-002", Type: policy.RuleMaxReceive, Max: intPtr(0), Label: "readonly"},
+		{ID: "DEN-001", Type: policy.RuleDenyReceive, Events: "audit/*"},
+	}}
+	require.NoError(t, p.Validate())
+
+	violations := p.Check([]policy.Subject{
+		{
+			Key:         "key-b",
+			Permissions: &domain.Permissions{Send: []string{"payments/settled", "orders/created", "orders/paid"}, Receive: []string{"payments/*"}},
+		},
+		{
+			Key:         "key-a",
+			Labels:      map[string]string{"exposure": "external"},
+			Permissions: &domain.Permissions{Send: []string{"orders/created"}, Receive: []string{"audit/login"}},
+		},
+		{
+			// Max 0 only applies to the keys the rule selects
+			Key:         "key-c",
+			Permissions: &domain.Permissions{Receive: []string{"orders/created"}},
+		},
+		{
+			Key:    "key-d",
+			Labels: map[string]string{"readonly": ""},
+		},
+	})
+
+	type found struct{ key, rule string }
+	var got []found
+	for _, v := range violations {
+		got = append(got, found{v.Key, v.RuleID})
+		assert.NotEmpty(t, v.Message)
+	}
+	assert.Equal(t, []found{
+		{"key-a", "DEN-001"},
+		{"key-a", "EXT-001"},
+		{"key-b", "LIM-001"},
+		{"key-b", "SOD-001"},
+	}, got)
+}
+
+func TestPolicyCheckCommand(t *testing.T) {
+	server := newFakeServer(t)
+	server.AddEvents("orders/created", "payments/settled")
+	server.AddKey("key-1", []string{"orders/created"}, []string{})
+	server.AddKey("key-2", []string{"payments/settled"}, []string{"payments/settled"})
+	cli := newCLI(t, server)
+
+	path := filepath.Join(cli.WorkDir, "policy.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+rules:
+  - id: SOD-001
+    type: no-send-and-receive
+    events: payments/*
+  - id: EXT-001
+    type: receive-only
+    label: exposure=external
+`), 0o600))
+
+	stdout, stderr, err := cli.Run("", "policy", "check", "-f", path)
+	require.Error(t, err)
+	assert.Contains(t, stderr, "1 policy violation(s) found")
+	assert.Contains(t, stdout, `"key": "key-2"`)
+
+	// Labels select the keys a rule applies to
+	cli.MustRun("access-key", "metadata", "set", "--key", "key-1", "--label", "exposure=external")
+	_, stderr, err = cli.Run("", "policy", "check", "-f", path, "--key", "key-1")
+	require.Error(t, err)
+	assert.Contains(t, stderr, "1 policy violation(s) found")
+
+	// Proposed permissions are checked without touching the key
+	cli.MustRun("policy", "check", "-f", path, "--key", "key-1", "--permissions", `{"send": [], "receive": ["orders/created"]}`)
+
+	// and permissions set refuses changes that violate the policy
+	_, _, err = cli.Run("", "access-key", "permissions", "set", "--key", "key-1",
+		"--permissions", `{"send": ["orders/created"], "receive": []}`, "--policy", path)
+	require.Error(t, err)
+	assert.Zero(t, server.CountRequests("POST", "/access-key/permissions/key-1"))
+
+	cli.MustRun("access-key", "permissions", "set", "--key", "key-1",
+		"--permissions", `{"send": [], "receive": ["orders/created"]}`, "--policy", path)
+	assert.Equal(t, []string{"orders/created"}, server.Permissions("key-1").Receive)
+}
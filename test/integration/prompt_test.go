@@ -0,0 +1,54 @@
+package integration
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// confirmingCLI runs commands with a profile that requires confirmation for
+// every change
+func confirmingCLI(t *testing.T, server *fakeServer) *cli {
+	cli := newCLI(t, server)
+	require.NoError(t, os.WriteFile(filepath.Join(cli.Dir, "config.yaml"), []byte("confirm_mutations: true\n"), 0o600))
+	return cli
+}
+
+func TestConfirmMutations(t *testing.T) {
+	server := createServer(t)
+	cli := confirmingCLI(t, server)
+	require.NoError(t, os.WriteFile(filepath.Join(cli.WorkDir, "permissions.yaml"), []byte("send: [orders/created]\n"), 0o600))
+
+	_, stderr, err := cli.Run("n\n", "access-key", "create", "--file", "permissions.yaml")
+	require.Error(t, err)
+	assert.Contains(t, stderr, `requires confirmation for changes. Run "ensync access-key create"?`)
+	assert.Contains(t, stderr, "aborted, no changes were made")
+	assert.Empty(t, server.Keys())
+
+	stdout, stderr, err := cli.Run("y\n", "access-key", "create", "--file", "permissions.yaml")
+	require.NoError(t, err, stderr)
+	assert.Contains(t, stdout, "orders/created")
+	require.Len(t, server.Keys(), 1)
+}
+
+func TestConfirmMutationsRefusesStdinData(t *testing.T) {
+	server := createServer(t)
+	cli := confirmingCLI(t, server)
+
+	// The first line of the permissions must not be taken as the answer
+	_, stderr, err := cli.Run("y\nsend: [orders/created]\n", "access-key", "create", "--file", "-")
+	require.Error(t, err)
+	assert.Contains(t, stderr, "cannot ask for confirmation while reading --file from stdin")
+	assert.Empty(t, server.Keys())
+	assert.Empty(t, server.Requests())
+
+	// Without the profile setting stdin is read as usual
+	require.NoError(t, os.Remove(filepath.Join(cli.Dir, "config.yaml")))
+	_, stderr, err = cli.Run("send: [orders/created]\n", "access-key", "create", "--file", "-")
+	require.NoError(t, err, stderr)
+	require.Len(t, server.Keys(), 1)
+	assert.Equal(t, []string{"orders/created"}, server.Permissions(server.Keys()[0]).Send)
+}
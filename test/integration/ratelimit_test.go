@@ -0,0 +1,114 @@
+package integration
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rossi1/ensync-cli/internal/api"
+	"github.com/rossi1/ensync-cli/internal/platform/ratelimit"
+)
+
+func rateLimitResponse(status int, headers map[string]string) *http.Response {
+	resp := &http.Response{StatusCode: status, Header: http.Header{}}
+	for name, value := range headers {
+		resp.Header.Set(name, value)
+	}
+	return resp
+}
+
+func TestRateLimiterFollowsServerBudget(t *testing.T) {
+	limiter := ratelimit.New(ratelimit.Config{
+		Default:  ratelimit.Limits{Rate: 10, Burst: 20, Max: 50},
+		Adaptive: true,
+	})
+
+	limiter.Observe(ratelimit.Read, rateLimitResponse(http.StatusOK, map[string]string{
+		ratelimit.RemainingHeader: "2",
+		ratelimit.ResetHeader:     "10",
+	}))
+	assert.InDelta(t, 0.2, limiter.Rate(ratelimit.Read), 0.001)
+
+	// Speeds up to the cap
+	limiter.Observe(ratelimit.Read, rateLimitResponse(http.StatusOK, map[string]string{
+		ratelimit.RemainingHeader: "1000",
+		ratelimit.ResetHeader:     "10",
+	}))
+	assert.InDelta(t, 50, limiter.Rate(ratelimit.Read), 0.001)
+
+	// Resets given as a Unix timestamp
+	limiter.Observe(ratelimit.Read, rateLimitResponse(http.StatusOK, map[string]string{
+		ratelimit.RemainingHeader: "100",
+		ratelimit.ResetHeader:     strconv.FormatInt(time.Now().Add(20*time.Second).Unix(), 10),
+	}))
+	assert.InDelta(t, 5, limiter.Rate(ratelimit.Read), 0.5)
+
+	// Writes are limited separately
+	assert.InDelta(t, 10, limiter.Rate(ratelimit.Write), 0.001)
+}
+
+func TestRateLimiterNotAdaptive(t *testing.T) {
+	limiter := ratelimit.New(ratelimit.Config{Default: ratelimit.Limits{Rate: 10, Burst: 20}})
+
+	limiter.Observe(ratelimit.Read, rateLimitResponse(http.StatusOK, map[string]string{
+		ratelimit.RemainingHeader: "2",
+		ratelimit.ResetHeader:     "10",
+	}))
+	assert.InDelta(t, 10, limiter.Rate(ratelimit.Read), 0.001)
+}
+
+func TestRateLimiterPausesBucket(t *testing.T) {
+	limiter := ratelimit.New(ratelimit.Config{Default: ratelimit.Limits{Rate: 10, Burst: 20}, Adaptive: true})
+
+	limiter.Observe(ratelimit.Write, rateLimitResponse(http.StatusTooManyRequests, map[string]string{"Retry-After": "2"}))
+	assert.InDelta(t, 5, limiter.Rate(ratelimit.Write), 0.001)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	require.NoError(t, limiter.Wait(ctx, ratelimit.Read))
+	require.ErrorIs(t, limiter.Wait(ctx, ratelimit.Write), context.DeadlineExceeded)
+
+	// An exhausted budget pauses until the reset
+	limiter.Observe(ratelimit.Read, rateLimitResponse(http.StatusOK, map[string]string{
+		ratelimit.RemainingHeader: "0",
+		ratelimit.ResetHeader:     "1",
+	}))
+	start := time.Now()
+	require.NoError(t, limiter.Wait(context.Background(), ratelimit.Read))
+	assert.GreaterOrEqual(t, time.Since(start), 900*time.Millisecond)
+}
+
+func TestClientWaitsAfterTooManyRequests(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if requests.Add(1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"message": "slow down"}`))
+			return
+		}
+		w.Header().Set(ratelimit.RemainingHeader, "100")
+		w.Header().Set(ratelimit.ResetHeader, "1")
+		w.Write([]byte(`{"resultsLength": 0, "results": []}`))
+	}))
+	defer server.Close()
+
+	limiter := ratelimit.New(ratelimit.Config{Default: ratelimit.Limits{Rate: 10, Burst: 20}, Adaptive: true})
+	client := api.NewClient(server.URL, "key", api.WithRetryPolicy(fastRetries), api.WithRateLimiter(limiter, nil))
+
+	start := time.Now()
+	require.NoError(t, listEvents(client))
+	assert.GreaterOrEqual(t, time.Since(start), time.Second)
+	assert.Equal(t, int32(2), requests.Load())
+
+	// The budget announced by the successful response raised the rate
+	assert.InDelta(t, 100, limiter.Rate(ratelimit.Read), 0.001)
+}
@@ -0,0 +1,81 @@
+package integration
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func matrixServer(t *testing.T) *fakeServer {
+	server := newFakeServer(t)
+	server.AddEvents("orders/created", "orders/paid", "payments/settled")
+	server.AddKey("key-1", []string{"orders/created"}, []string{"payments/*"})
+	server.AddKey("key-2", []string{"orders/*"}, []string{"orders/created"})
+	return server
+}
+
+func TestReportMatrixCSV(t *testing.T) {
+	cli := newCLI(t, matrixServer(t))
+
+	out := cli.MustRun("report", "matrix")
+	assert.Equal(t, strings.Join([]string{
+		"key,label,orders/created,orders/paid,payments/settled",
+		"key-1,,S,,R",
+		"key-2,,SR,S,",
+		"",
+	}, "\n"), out)
+}
+
+func TestReportMatrixMarkdownWithPrefix(t *testing.T) {
+	cli := newCLI(t, matrixServer(t))
+
+	out := cli.MustRun("report", "matrix", "--format", "md", "--prefix", "orders/")
+	assert.Equal(t, strings.Join([]string{
+		"| key | label | orders/created | orders/paid |",
+		"| --- | --- | :---: | :---: |",
+		"| key-1 |  | S |  |",
+		"| key-2 |  | SR | S |",
+		"",
+	}, "\n"), out)
+}
+
+func TestReportMatrixHTMLFile(t *testing.T) {
+	cli := newCLI(t, matrixServer(t))
+	cli.MustRun("access-key", "metadata", "set", "--key", "key-2", "--label", "team=orders")
+
+	path := filepath.Join(t.TempDir(), "matrix.html")
+	assert.Empty(t, cli.MustRun("report", "matrix", "--format", "html", "--selector", "team=orders", "-o", path))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	html := string(data)
+	assert.True(t, strings.HasPrefix(html, "<!DOCTYPE html>"))
+	assert.Contains(t, html, "<style>")
+	assert.Contains(t, html, "<code>key-2</code></td><td>team=orders</td>")
+	assert.NotContains(t, html, "key-1")
+}
+
+func TestReportMatrixRejectsFormatBeforeWriting(t *testing.T) {
+	server := matrixServer(t)
+	cli := newCLI(t, server)
+
+	path := filepath.Join(t.TempDir(), "matrix.pdf")
+	_, stderr, err := cli.Run("", "report", "matrix", "--format", "pdf", "-o", path)
+	require.Error(t, err)
+	assert.Contains(t, stderr, `unsupported format "pdf"`)
+
+	assert.NoFileExists(t, path)
+	assert.Empty(t, server.Requests())
+}
+
+func TestReportMatrixReportsWriteErrors(t *testing.T) {
+	cli := newCLI(t, matrixServer(t))
+
+	_, stderr, err := cli.Run("", "report", "matrix", "-o", filepath.Join(t.TempDir(), "missing", "matrix.csv"))
+	require.Error(t, err)
+	assert.Contains(t, stderr, "failed to create output file")
+}
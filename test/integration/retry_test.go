@@ -0,0 +1,158 @@
+package integration
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+
+	"github.com/rossi1/ensync-cli/internal/api"
+	"github.com/rossi1/ensync-cli/internal/domain"
+)
+
+// flakyServer fails the first failures requests with status, setting
+// Retry-After when retryAfter is not empty, and counts the requests
+func flakyServer(t *testing.T, failures int32, status int, retryAfter string) (*httptest.Server, *atomic.Int32) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if requests.Add(1) <= failures {
+			if retryAfter != "" {
+				w.Header().Set("Retry-After", retryAfter)
+			}
+			w.WriteHeader(status)
+			w.Write([]byte(`{"message": "try again later"}`))
+			return
+		}
+		w.Write([]byte(`{"resultsLength": 0, "results": []}`))
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+var fastRetries = api.RetryPolicy{MaxAttempts: 3, MinWait: 10 * time.Millisecond, MaxWait: 50 * time.Millisecond}
+
+func TestRetryIdempotentRequest(t *testing.T) {
+	server, requests := flakyServer(t, 2, http.StatusServiceUnavailable, "")
+	core, logs := observer.New(zap.DebugLevel)
+
+	client := api.NewClient(server.URL, "key", api.WithRetryPolicy(fastRetries), api.WithLogger(zap.New(core)))
+	require.NoError(t, listEvents(client))
+	assert.Equal(t, int32(3), requests.Load())
+
+	attempts := logs.FilterMessage("Attempting request").All()
+	require.Len(t, attempts, 3)
+	for i, entry := range attempts {
+		assert.Equal(t, int64(i+1), entry.ContextMap()["attempt"])
+	}
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	server, requests := flakyServer(t, 10, http.StatusServiceUnavailable, "")
+
+	err := listEvents(api.NewClient(server.URL, "key", api.WithRetryPolicy(fastRetries)))
+	require.Error(t, err)
+	assert.Equal(t, int32(3), requests.Load())
+
+	// The error of the server is reported
+	var apiErr *api.APIError
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusServiceUnavailable, apiErr.StatusCode)
+	assert.Equal(t, "try again later", apiErr.Message)
+}
+
+func TestRetryNonIdempotentRequest(t *testing.T) {
+	server, requests := flakyServer(t, 1, http.StatusServiceUnavailable, "")
+	client := api.NewClient(server.URL, "key", api.WithRetryPolicy(fastRetries))
+
+	err := client.CreateEvent(context.Background(), &domain.Event{Name: "orders/created"})
+	require.Error(t, err)
+	assert.Equal(t, int32(1), requests.Load())
+
+	server, requests = flakyServer(t, 1, http.StatusServiceUnavailable, "")
+	client = api.NewClient(server.URL, "key", api.WithRetryPolicy(fastRetries))
+
+	require.NoError(t, client.CreateEvent(api.ForceRetry(context.Background()), &domain.Event{Name: "orders/created"}))
+	assert.Equal(t, int32(2), requests.Load())
+}
+
+func TestRetryPutRequest(t *testing.T) {
+	// PUT is not retried unless the call opts in
+	server, requests := flakyServer(t, 1, http.StatusServiceUnavailable, "")
+	client := api.NewClient(server.URL, "key", api.WithRetryPolicy(fastRetries))
+
+	event := &domain.Event{ID: 1, Name: "orders/created"}
+	require.Error(t, client.UpdateEvent(context.Background(), event))
+	assert.Equal(t, int32(1), requests.Load())
+
+	server, requests = flakyServer(t, 1, http.StatusServiceUnavailable, "")
+	client = api.NewClient(server.URL, "key", api.WithRetryPolicy(fastRetries))
+
+	require.NoError(t, client.UpdateEvent(api.ForceRetry(context.Background()), event))
+	assert.Equal(t, int32(2), requests.Load())
+}
+
+func TestRetrySetAccessKeyPermissions(t *testing.T) {
+	// Setting permissions is a POST, but repeating it is harmless
+	server, requests := flakyServer(t, 1, http.StatusServiceUnavailable, "")
+	client := api.NewClient(server.URL, "key", api.WithRetryPolicy(fastRetries))
+
+	permissions := &domain.Permissions{Send: []string{"orders/created"}, Receive: []string{}}
+	require.NoError(t, client.SetAccessKeyPermissions(context.Background(), "key-1", permissions))
+	assert.Equal(t, int32(2), requests.Load())
+
+	// An explicit NoRetry still wins
+	server, requests = flakyServer(t, 1, http.StatusServiceUnavailable, "")
+	client = api.NewClient(server.URL, "key", api.WithRetryPolicy(fastRetries))
+
+	require.Error(t, client.SetAccessKeyPermissions(api.NoRetry(context.Background()), "key-1", permissions))
+	assert.Equal(t, int32(1), requests.Load())
+}
+
+func TestNoRetry(t *testing.T) {
+	server, requests := flakyServer(t, 1, http.StatusServiceUnavailable, "")
+	client := api.NewClient(server.URL, "key", api.WithRetryPolicy(fastRetries))
+
+	_, err := client.ListEvents(api.NoRetry(context.Background()), &api.ListParams{Limit: 1})
+	require.Error(t, err)
+	assert.Equal(t, int32(1), requests.Load())
+}
+
+func TestRetryAfter(t *testing.T) {
+	server, requests := flakyServer(t, 1, http.StatusTooManyRequests, "1")
+	client := api.NewClient(server.URL, "key", api.WithRetryPolicy(fastRetries))
+
+	start := time.Now()
+	require.NoError(t, listEvents(client))
+	assert.GreaterOrEqual(t, time.Since(start), time.Second)
+	assert.Equal(t, int32(2), requests.Load())
+
+	date := time.Now().Add(2 * time.Second).UTC().Format(http.TimeFormat)
+	server, requests = flakyServer(t, 1, http.StatusServiceUnavailable, date)
+	client = api.NewClient(server.URL, "key", api.WithRetryPolicy(fastRetries))
+
+	start = time.Now()
+	require.NoError(t, listEvents(client))
+	assert.GreaterOrEqual(t, time.Since(start), 500*time.Millisecond)
+	assert.Equal(t, int32(2), requests.Load())
+}
+
+func TestRetryAfterTooLong(t *testing.T) {
+	server, requests := flakyServer(t, 1, http.StatusTooManyRequests, strconv.Itoa(3600))
+	client := api.NewClient(server.URL, "key", api.WithRetryPolicy(fastRetries))
+
+	start := time.Now()
+	err := listEvents(client)
+	require.Error(t, err)
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Equal(t, int32(1), requests.Load())
+	assert.Contains(t, err.Error(), "429")
+}
@@ -0,0 +1,116 @@
+package integration
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rossi1/ensync-cli/internal/domain"
+	"github.com/rossi1/ensync-cli/internal/role"
+)
+
+type roleDrift struct {
+	Key            string   `json:"key"`
+	Roles          []string `json:"roles"`
+	MissingSend    []string `json:"missingSend"`
+	ExtraSend      []string `json:"extraSend"`
+	MissingReceive []string `json:"missingReceive"`
+	ExtraReceive   []string `json:"extraReceive"`
+}
+
+func writeRoles(t *testing.T, cli *cli, roles string) {
+	require.NoError(t, os.WriteFile(filepath.Join(cli.Dir, "config.yaml"), []byte(roles), 0o600))
+}
+
+func TestRoleResolve(t *testing.T) {
+	defs := map[string]*domain.Permissions{
+		"orderconsumer": {Receive: []string{"orders/created", "orders/paid"}},
+		"auditor":       {Receive: []string{"orders/*", "orders/paid"}},
+	}
+
+	resolved, err := role.Resolve(defs, []string{"OrderConsumer", "auditor"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{}, resolved.Send)
+	assert.Equal(t, []string{"orders/created", "orders/paid", "orders/*"}, resolved.Receive)
+
+	_, err = role.Resolve(defs, []string{"admin"})
+	assert.ErrorContains(t, err, `unknown role "admin"`)
+}
+
+func TestRoleGrantAndDiff(t *testing.T) {
+	server := newFakeServer(t)
+	server.AddEvents("orders/created", "orders/paid", "orders/shipped", "payments/settled")
+	cli := newCLI(t, server)
+
+	// viper lower-cases the role names of the config file
+	writeRoles(t, cli, `
+roles:
+  OrderConsumer:
+    receive: [orders/created, orders/paid]
+  PaymentPublisher:
+    send: [payments/settled]
+`)
+
+	cli.MustRun("access-key", "create", "--role", "OrderConsumer")
+	cli.MustRun("access-key", "create", "--role", "PaymentPublisher")
+	require.Equal(t, []string{"key-1", "key-2"}, server.Keys())
+	assert.Equal(t, []string{"orders/created", "orders/paid"}, server.Permissions("key-1").Receive)
+
+	cli.MustRun("access-key", "permissions", "set", "--key", "key-2", "--role", "paymentpublisher", "--role", "OrderConsumer")
+	assert.Equal(t, []string{"payments/settled"}, server.Permissions("key-2").Send)
+	assert.Equal(t, []string{"orders/created", "orders/paid"}, server.Permissions("key-2").Receive)
+
+	var drift []roleDrift
+	cli.RunJSON(&drift, "role", "diff")
+	assert.Empty(t, drift)
+
+	// Changing a role definition makes its keys drift
+	writeRoles(t, cli, `
+roles:
+  OrderConsumer:
+    receive: [orders/created, orders/shipped]
+  PaymentPublisher:
+    send: [payments/settled]
+`)
+
+	cli.RunJSON(&drift, "role", "diff", "--role", "ORDERCONSUMER")
+	require.Len(t, drift, 2)
+	assert.Equal(t, "key-1", drift[0].Key)
+	assert.Equal(t, []string{"OrderConsumer"}, drift[0].Roles)
+	assert.Equal(t, []string{"orders/shipped"}, drift[0].MissingReceive)
+	assert.Equal(t, []string{"orders/paid"}, drift[0].ExtraReceive)
+
+	cli.RunJSON(&drift, "role", "diff", "--role", "paymentpublisher")
+	require.Len(t, drift, 1)
+	assert.Equal(t, "key-2", drift[0].Key)
+
+	// Refusing the prompt changes nothing
+	_, stderr, err := cli.Run("no\n", "role", "diff", "--apply")
+	require.NoError(t, err)
+	assert.Contains(t, stderr, "Aborted")
+	assert.Equal(t, []string{"orders/created", "orders/paid"}, server.Permissions("key-1").Receive)
+
+	_, stderr, err = cli.Run("", "role", "diff", "--apply", "--yes")
+	require.NoError(t, err, stderr)
+	assert.Contains(t, stderr, "Updated key-1")
+	assert.Contains(t, stderr, "Updated key-2")
+	assert.Equal(t, []string{"orders/created", "orders/shipped"}, server.Permissions("key-1").Receive)
+	assert.Equal(t, []string{"payments/settled"}, server.Permissions("key-2").Send)
+
+	cli.RunJSON(&drift, "role", "diff")
+	assert.Empty(t, drift)
+}
+
+func TestRoleUnknown(t *testing.T) {
+	server := newFakeServer(t)
+	cli := newCLI(t, server)
+	writeRoles(t, cli, "roles:\n  auditor:\n    receive: [orders/*]\n")
+
+	_, stderr, err := cli.Run("", "access-key", "create", "--role", "admin")
+	require.Error(t, err)
+	assert.Contains(t, stderr, `unknown role "admin"`)
+	assert.Empty(t, server.Keys())
+}
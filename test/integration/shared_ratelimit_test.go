@@ -0,0 +1,114 @@
+//go:build unix
+
+package integration
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rossi1/ensync-cli/internal/platform/ratelimit"
+)
+
+func sharedLimiter(dir string) *ratelimit.Limiter {
+	return ratelimit.New(ratelimit.Config{Default: ratelimit.Limits{Rate: 10, Burst: 1}, SharedDir: dir})
+}
+
+func TestSharedRateLimitBudget(t *testing.T) {
+	dir := t.TempDir()
+	first, second := sharedLimiter(dir), sharedLimiter(dir)
+
+	// 11 requests at 10 per second take a second together, where each
+	// limiter alone would let its 6 or 5 through in half of that
+	start := time.Now()
+	for i := 0; i < 11; i++ {
+		limiter := first
+		if i%2 == 1 {
+			limiter = second
+		}
+		require.NoError(t, limiter.Wait(context.Background(), ratelimit.Write))
+	}
+	assert.GreaterOrEqual(t, time.Since(start), 900*time.Millisecond)
+
+	// Buckets are shared separately
+	start = time.Now()
+	require.NoError(t, second.Wait(context.Background(), ratelimit.Read))
+	assert.Less(t, time.Since(start), 50*time.Millisecond)
+}
+
+func TestSharedRateLimitPause(t *testing.T) {
+	dir := t.TempDir()
+	first, second := sharedLimiter(dir), sharedLimiter(dir)
+
+	first.Observe(ratelimit.Read, rateLimitResponse(http.StatusTooManyRequests, map[string]string{"Retry-After": "1"}))
+
+	start := time.Now()
+	require.NoError(t, second.Wait(context.Background(), ratelimit.Read))
+	assert.GreaterOrEqual(t, time.Since(start), 900*time.Millisecond)
+}
+
+func TestSharedRateLimitCorruptFile(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "read.json"), []byte(`{"tokens": 0.`), 0o600))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, sharedLimiter(dir).Wait(ctx, ratelimit.Read))
+}
+
+// TestSharedRateLimitHoldLock is run by TestSharedRateLimitCrashedProcess in
+// a child process, which locks the bucket file and waits to be killed
+func TestSharedRateLimitHoldLock(t *testing.T) {
+	path := os.Getenv("ENSYNC_TEST_LOCK_FILE")
+	if path == "" {
+		t.Skip("only run as a child process")
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	require.NoError(t, err)
+	require.NoError(t, syscall.Flock(int(file.Fd()), syscall.LOCK_EX))
+	os.Stdout.WriteString("locked\n")
+	time.Sleep(time.Minute)
+}
+
+func TestSharedRateLimitCrashedProcess(t *testing.T) {
+	dir := t.TempDir()
+
+	child := exec.Command(os.Args[0], "-test.run=^TestSharedRateLimitHoldLock$")
+	child.Env = append(os.Environ(), "ENSYNC_TEST_LOCK_FILE="+filepath.Join(dir, "read.json"))
+	stdout, err := child.StdoutPipe()
+	require.NoError(t, err)
+	require.NoError(t, child.Start())
+
+	buf := make([]byte, len("locked\n"))
+	_, err = stdout.Read(buf)
+	require.NoError(t, err)
+
+	// The lock is held while the child runs
+	blockedCtx, cancelBlocked := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancelBlocked()
+	done := make(chan error, 1)
+	go func() { done <- sharedLimiter(dir).Wait(blockedCtx, ratelimit.Read) }()
+	select {
+	case err := <-done:
+		t.Fatalf("Wait returned while the lock was held: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// and released by the kernel when it dies
+	require.NoError(t, child.Process.Kill())
+	child.Wait()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, sharedLimiter(dir).Wait(ctx, ratelimit.Read))
+	<-done
+}
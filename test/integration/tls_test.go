@@ -0,0 +1,134 @@
+package integration
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rossi1/ensync-cli/internal/api"
+)
+
+func newTLSServer(t *testing.T) *httptest.Server {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"resultsLength": 0, "results": []}`))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// writePEM writes DER blocks as PEM to a file in dir and returns its path
+func writePEM(t *testing.T, dir, name, blockType string, der []byte) string {
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600))
+	return path
+}
+
+func listEvents(client *api.Client) error {
+	_, err := client.ListEvents(context.Background(), &api.ListParams{Limit: 1})
+	return err
+}
+
+func TestTLSCustomCA(t *testing.T) {
+	server := newTLSServer(t)
+	caFile := writePEM(t, t.TempDir(), "ca.pem", "CERTIFICATE", server.Certificate().Raw)
+
+	err := listEvents(api.NewClient(server.URL, "key"))
+	require.Error(t, err)
+
+	require.NoError(t, listEvents(api.NewClient(server.URL, "key", api.WithCAFile(caFile))))
+}
+
+func TestTLSServerName(t *testing.T) {
+	server := newTLSServer(t)
+	caFile := writePEM(t, t.TempDir(), "ca.pem", "CERTIFICATE", server.Certificate().Raw)
+
+	// The httptest certificate is valid for example.com
+	require.NoError(t, listEvents(api.NewClient(server.URL, "key", api.WithCAFile(caFile), api.WithServerName("example.com"))))
+
+	err := listEvents(api.NewClient(server.URL, "key", api.WithCAFile(caFile), api.WithServerName("ensync.example.org")))
+	require.Error(t, err)
+}
+
+func TestTLSPinning(t *testing.T) {
+	server := newTLSServer(t)
+	pin := api.SPKIHash(server.Certificate())
+
+	require.NoError(t, listEvents(api.NewClient(server.URL, "key", api.WithInsecureSkipVerify(), api.WithPinnedSPKI("sha256/"+pin))))
+
+	other := "sha256/" + "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="
+	err := listEvents(api.NewClient(server.URL, "key", api.WithInsecureSkipVerify(), api.WithPinnedSPKI(other)))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "pinned public key")
+
+	err = listEvents(api.NewClient(server.URL, "key", api.WithPinnedSPKI("not-a-pin")))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid TLS configuration")
+}
+
+func TestTLSInsecureSkipVerify(t *testing.T) {
+	server := newTLSServer(t)
+
+	require.NoError(t, listEvents(api.NewClient(server.URL, "key", api.WithInsecureSkipVerify())))
+}
+
+func TestTLSClientCertificate(t *testing.T) {
+	dir := t.TempDir()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "ensync-cli"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	clientCert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	certFile := writePEM(t, dir, "client.pem", "CERTIFICATE", der)
+	keyFile := writePEM(t, dir, "client-key.pem", "EC PRIVATE KEY", keyDER)
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"resultsLength": 0, "results": []}`))
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	server.StartTLS()
+	defer server.Close()
+
+	caFile := writePEM(t, dir, "ca.pem", "CERTIFICATE", server.Certificate().Raw)
+
+	err = listEvents(api.NewClient(server.URL, "key", api.WithCAFile(caFile)))
+	require.Error(t, err)
+
+	require.NoError(t, listEvents(api.NewClient(server.URL, "key", api.WithCAFile(caFile), api.WithClientCertificate(certFile, keyFile))))
+
+	err = listEvents(api.NewClient(server.URL, "key", api.WithCAFile(caFile), api.WithClientCertificate(certFile, "")))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "both a client certificate and key file")
+}
@@ -0,0 +1,104 @@
+package integration
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rossi1/ensync-cli/internal/api"
+)
+
+func eventsHandler(paths chan<- string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if paths != nil {
+			paths <- r.URL.Path
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"resultsLength": 0, "results": []}`))
+	})
+}
+
+func TestUnixSocketBaseURL(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "ensync.sock")
+	listener, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+
+	paths := make(chan string, 1)
+	server := httptest.NewUnstartedServer(eventsHandler(paths))
+	server.Listener.Close()
+	server.Listener = listener
+	server.Start()
+	defer server.Close()
+
+	// A proxy must not be used for the socket
+	client := api.NewClient("unix://"+socket+":/api/v1/ensync", "key", api.WithProxy("http://127.0.0.1:1", ""))
+	require.NoError(t, listEvents(client))
+	assert.Equal(t, "/api/v1/ensync/event", <-paths)
+
+	client = api.NewClient("unix://"+socket, "key")
+	require.NoError(t, listEvents(client))
+	assert.Equal(t, "/event", <-paths)
+}
+
+func TestProxy(t *testing.T) {
+	server := httptest.NewServer(eventsHandler(nil))
+	defer server.Close()
+
+	var proxied atomic.Int32
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied.Add(1)
+		// Requests to an http server through a proxy carry the absolute URL
+		assert.Equal(t, server.URL+"/event", r.URL.Scheme+"://"+r.URL.Host+r.URL.Path)
+		eventsHandler(nil).ServeHTTP(w, r)
+	}))
+	defer proxy.Close()
+
+	require.NoError(t, listEvents(api.NewClient(server.URL, "key", api.WithProxy(proxy.URL, ""))))
+	assert.Equal(t, int32(1), proxied.Load())
+
+	for _, noProxy := range []string{"127.0.0.1", "127.0.0.0/8", "example.com, 127.0.0.1", "*"} {
+		require.NoError(t, listEvents(api.NewClient(server.URL, "key", api.WithProxy(proxy.URL, noProxy))), noProxy)
+	}
+	assert.Equal(t, int32(1), proxied.Load())
+
+	// A different port does not match
+	require.NoError(t, listEvents(api.NewClient(server.URL, "key", api.WithProxy(proxy.URL, "127.0.0.1:1"))))
+	assert.Equal(t, int32(2), proxied.Load())
+
+	err := listEvents(api.NewClient(server.URL, "key", api.WithProxy("ftp://proxy.example.com", "")))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid connection configuration")
+}
+
+func TestDialerAndPool(t *testing.T) {
+	server := httptest.NewServer(eventsHandler(nil))
+	defer server.Close()
+
+	var dials atomic.Int32
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		dials.Add(1)
+		var dialer net.Dialer
+		return dialer.DialContext(ctx, network, server.Listener.Addr().String())
+	}
+
+	client := api.NewClient("http://ensync.invalid", "key",
+		api.WithDialer(dial),
+		api.WithTransport(api.TransportConfig{MaxIdleConnsPerHost: 1, MaxConnsPerHost: 1}),
+	)
+	for i := 0; i < 3; i++ {
+		require.NoError(t, listEvents(client))
+	}
+	// The idle connection is reused
+	assert.Equal(t, int32(1), dials.Load())
+
+	err := listEvents(api.NewClient(server.URL, "key", api.WithCustomHTTPClient(http.DefaultClient), api.WithDialer(dial)))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "custom HTTP client")
+}